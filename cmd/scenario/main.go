@@ -63,24 +63,38 @@ func createCache(envs map[string]string, parameters ...any) interface {
 	internal.Clearer
 	cache.Cache
 } {
+	var c interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+	}
+
 	switch envs["CACHE_TYPE"] {
 	default:
 		return nil
 	case "memory":
-		return cache.NewMemory(parameters...)
+		c = cache.NewMemory(parameters...)
 	case "redis":
-		return cache.NewRedis(parameters...)
+		c = cache.NewRedis(parameters...)
+	case "tiered":
+		c = cache.NewTiered(parameters...)
 	case "stash-memory":
 		stash := memory.New()
 		_ = stash.Configure(envs)
 		parameters = append(parameters, stash)
-		return cache.NewStash(parameters...)
+		c = cache.NewStash(parameters...)
 	case "stash-redis":
 		stash := redis.New()
 		_ = stash.Configure(envs)
 		parameters = append(parameters, stash)
-		return cache.NewStash(parameters...)
+		c = cache.NewStash(parameters...)
+	}
+	if invalidationEnabled, _ := strconv.ParseBool(envs["CACHE_INVALIDATION_ENABLED"]); invalidationEnabled {
+		parameters = append(parameters, c)
+		return cache.NewInvalidator(parameters...)
 	}
+	return c
 }
 
 func scenarioStampedingHerd(ctx context.Context, envs map[string]string, logger utilities.Logger,
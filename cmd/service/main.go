@@ -4,6 +4,7 @@ import (
 	"context"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"sync"
 	"syscall"
@@ -61,24 +62,38 @@ func createCache(envs map[string]string, parameters ...any) interface {
 	internal.Clearer
 	cache.Cache
 } {
+	var c interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+	}
+
 	switch envs["CACHE_TYPE"] {
 	default:
 		return nil
 	case "memory":
-		return cache.NewMemory(parameters...)
+		c = cache.NewMemory(parameters...)
 	case "redis":
-		return cache.NewRedis(parameters...)
+		c = cache.NewRedis(parameters...)
+	case "tiered":
+		c = cache.NewTiered(parameters...)
 	case "stash-memory":
 		stash := memory.New()
 		_ = stash.Configure(envs)
 		parameters = append(parameters, stash)
-		return cache.NewStash(parameters...)
+		c = cache.NewStash(parameters...)
 	case "stash-redis":
 		stash := redis.New()
 		_ = stash.Configure(envs)
 		parameters = append(parameters, stash)
-		return cache.NewStash(parameters...)
+		c = cache.NewStash(parameters...)
+	}
+	if invalidationEnabled, _ := strconv.ParseBool(envs["CACHE_INVALIDATION_ENABLED"]); invalidationEnabled {
+		parameters = append(parameters, c)
+		return cache.NewInvalidator(parameters...)
 	}
+	return c
 }
 
 func Main(pwd string, args []string, envs map[string]string, osSignal chan os.Signal) error {
@@ -113,7 +128,10 @@ func Main(pwd string, args []string, envs map[string]string, osSignal chan os.Si
 	}()
 
 	// create cache
-	cache := createCache(envs, logger)
+	//KIM: the cache is given its own counter so that counters kept
+	// by the cache (e.g. hits per tier) aren't mixed in with those
+	// kept by logic and served by /cachecounters
+	cache := createCache(envs, logger, utilities.NewCounter())
 	if cache != nil {
 		if err := cache.Configure(envs); err != nil {
 			return err
@@ -128,6 +146,21 @@ func Main(pwd string, args []string, envs map[string]string, osSignal chan os.Si
 		}()
 	}
 
+	//create warmer, configure and open; the service isn't ready
+	// until the cache has been warmed up
+	warmer := logic.NewWarmer(sql, logger, cache)
+	if err := warmer.Configure(envs); err != nil {
+		return err
+	}
+	if err := warmer.Open(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if err := warmer.Close(context.Background()); err != nil {
+			logger.Error(context.Background(), "error while closing warmer: %s", err)
+		}
+	}()
+
 	//create logic, configure and open
 	logic := logic.NewLogic(sql, logger, counter, cache)
 	if err := logic.Configure(envs); err != nil {
@@ -143,7 +176,7 @@ func Main(pwd string, args []string, envs map[string]string, osSignal chan os.Si
 	}()
 
 	//create service, configure and open
-	service := service.NewService(logic, cache, logger, counter, timers)
+	service := service.NewService(logic, cache, logger, counter, timers, warmer)
 	if err := service.Configure(envs); err != nil {
 		return err
 	}
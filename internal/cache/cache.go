@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/antonio-alexander/go-blog-cache/internal/data"
 )
@@ -20,12 +22,31 @@ var (
 	ErrSleepNotFoundCached          = data.NewNotCachedError("sleep not found; cached")
 	ErrSleepReadSet                 = data.NewNotCachedRetryError("sleep not cached, read set")
 	ErrSleepReadAlreadySet          = data.NewNotCachedRetryError("sleep not cached, read already set")
+	ErrEmployeeStale                = data.NewStaleError("employee stale")
+	ErrEmployeeSearchStale          = data.NewStaleError("employee search stale")
+	ErrSleepStale                   = data.NewStaleError("sleep stale")
+	ErrEmployeeRefresh              = data.NewRefreshError("employee should be refreshed early")
+	ErrEmployeeSearchRefresh        = data.NewRefreshError("employee search should be refreshed early")
+	ErrSleepRefresh                 = data.NewRefreshError("sleep should be refreshed early")
+	ErrMutexNotAcquired             = data.NewError("mutex not acquired")
+	ErrMutexNotHeld                 = data.NewError("mutex not held")
+	ErrFencingTokenStale            = data.NewError("write rejected; fencing token stale")
+	ErrFillInvalidated              = data.NewError("write rejected; entry invalidated after fill began")
+	ErrLeaseInvalid                 = data.NewError("write rejected; lease invalid or expired")
+	ErrVersioningUnsupported        = data.NewError("cache doesn't support versioning")
+	ErrWaitUnsupported              = data.NewError("cache doesn't support waiting on fills")
+	ErrCacheEntryNotFound           = data.NewNotFoundError("cache entry not found")
+	ErrCacheEntryTypeUnsupported    = data.NewError("cache entry type not supported")
+	ErrInspectionUnsupported        = data.NewError("cache doesn't support inspection")
 )
 
 func ErrSearchKey(err error) error {
 	return data.NewError(fmt.Errorf("error while creating search key: %w", err))
 }
 
+// Cache describes the operations all caches support; if a cache has a
+// hard ttl configured, reads of an entry past its soft ttl (but not its
+// hard ttl) will return the stale value alongside a stale error
 type Cache interface {
 	EmployeeRead(ctx context.Context, empNo int64) (*data.Employee, error)
 	EmployeesRead(ctx context.Context, search data.EmployeeSearch) ([]*data.Employee, error)
@@ -33,11 +54,144 @@ type Cache interface {
 	EmployeesDelete(ctx context.Context, empNos ...int64) error
 	EmployeesNotFoundWrite(ctx context.Context, search data.EmployeeSearch, empNos ...int64) error
 
+	//EmployeeSearchesRead returns the criteria of each cached search
+	// (including searches cached as not found) by search key
+	EmployeeSearchesRead(ctx context.Context) (map[string]data.EmployeeSearch, error)
+	EmployeeSearchesDelete(ctx context.Context, searchKeys ...string) error
+
 	SleepRead(ctx context.Context, sleepId string) (*data.Sleep, error)
 	SleepWrite(ctx context.Context, sleep *data.Sleep) error
 	SleepsDelete(ctx context.Context, sleepIds ...string) error
 }
 
+// Mutex is a distributed lock; a mutex should only be used by
+// a single goroutine at a time
+type Mutex interface {
+	Lock(ctx context.Context) (fencingToken int64, err error)
+	Unlock(ctx context.Context) error
+}
+
+// Locker can be implemented by caches that can provide a
+// distributed lock
+type Locker interface {
+	NewMutex(key string) Mutex
+}
+
+// Versioner can be implemented by caches that version entries; each
+// invalidation (delete or clear) increments the generation, a fill should
+// read the generation before it reads from the source and attach it to
+// the context of its write (see CtxWithGeneration) so that the write is
+// rejected if any entry was invalidated after the fill began
+type Versioner interface {
+	Generation(ctx context.Context) (int64, error)
+}
+
+// FillBegin attaches the generation of the cache (if it's a Versioner)
+// to the context, it should be called before a fill reads from the source
+func FillBegin(ctx context.Context, c Cache) (context.Context, error) {
+	versioner, ok := c.(Versioner)
+	if !ok {
+		return ctx, nil
+	}
+	generation, err := versioner.Generation(ctx)
+	if err != nil {
+		return ctx, err
+	}
+	return CtxWithGeneration(ctx, generation), nil
+}
+
+// Waiter can be implemented by caches that can notify readers waiting on
+// a fill in progress (i.e. a read that returned a read already set error),
+// FillWait blocks until the entry is written or deleted, the lease of the
+// fill expires or the context is done
+type Waiter interface {
+	FillWait(ctx context.Context, entryType, key string) error
+}
+
+// FillWait will wait on the fill in progress for the given entry if the
+// cache is a Waiter, otherwise ErrWaitUnsupported is returned
+func FillWait(ctx context.Context, c Cache, entryType, key string) error {
+	waiter, ok := c.(Waiter)
+	if !ok {
+		return ErrWaitUnsupported
+	}
+	return waiter.FillWait(ctx, entryType, key)
+}
+
+// storedEmployeeSearch is how an employee search is stored (or serialized),
+// the search (criteria) is stored alongside the employees it found
+type storedEmployeeSearch struct {
+	Search data.EmployeeSearch `json:"search"`
+	EmpNos []int64             `json:"emp_nos"`
+}
+
+// Inspector can be implemented by caches that can list, read and evict
+// individual entries; an empty entry type lists the keys of all types
+type Inspector interface {
+	KeysRead(ctx context.Context, entryType string) ([]data.CacheKey, error)
+	EntryRead(ctx context.Context, entryType, key string) (*data.CacheEntry, error)
+	EntryDelete(ctx context.Context, entryType, key string) error
+}
+
+// entryTypes returns the entry types to inspect, all entry types are
+// returned if the entry type is empty
+func entryTypes(entryType string) ([]string, error) {
+	switch entryType {
+	default:
+		return nil, ErrCacheEntryTypeUnsupported
+	case "":
+		return []string{entryTypeEmployee, entryTypeEmployeeSearch, entryTypeSleep}, nil
+	case entryTypeEmployee, entryTypeEmployeeSearch, entryTypeSleep:
+		return []string{entryType}, nil
+	}
+}
+
+func newCacheKey(entryType, key string, e entryExpiry, staleTTL time.Duration) data.CacheKey {
+	age := time.Since(time.Unix(0, e.cachedAt))
+	return data.CacheKey{
+		EntryType: entryType,
+		Key:       key,
+		CachedAt:  e.cachedAt,
+		Age:       age.Milliseconds(),
+		TTL:       max(e.expiration(staleTTL)-age, 0).Milliseconds(),
+		Stale:     staleTTL > 0 && age > e.ttl,
+	}
+}
+
+// newEntryValue returns a value that an entry of the
+// given entry type can be decoded into
+func newEntryValue(entryType string) any {
+	switch entryType {
+	default:
+		return nil
+	case entryTypeEmployee:
+		return &data.Employee{}
+	case entryTypeEmployeeSearch:
+		return &storedEmployeeSearch{}
+	case entryTypeSleep:
+		return &data.Sleep{}
+	}
+}
+
+// entryDelete will evict a single entry using the cache's own deletes
+// so any side effects (e.g. invalidation) still occur
+func entryDelete(ctx context.Context, c Cache, entryType, key string) error {
+	switch entryType {
+	default:
+		return ErrCacheEntryTypeUnsupported
+	case entryTypeEmployee:
+		empNo, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return err
+		}
+		return c.EmployeesDelete(ctx, empNo)
+	case entryTypeEmployeeSearch:
+		return c.EmployeeSearchesDelete(ctx, key)
+	case entryTypeSleep:
+		return c.SleepsDelete(ctx, key)
+	}
+}
+
 func copyEmployee(e *data.Employee) *data.Employee {
 	employee := &data.Employee{}
 	*employee = *e
@@ -49,3 +203,13 @@ func copySleep(s *data.Sleep) *data.Sleep {
 	*sleep = *s
 	return sleep
 }
+
+func employeeSize(e *data.Employee) int {
+	bytes, _ := e.MarshalBinary()
+	return len(bytes)
+}
+
+func sleepSize(s *data.Sleep) int {
+	bytes, _ := s.MarshalBinary()
+	return len(bytes)
+}
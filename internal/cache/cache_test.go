@@ -2,9 +2,12 @@ package cache_test
 
 import (
 	"context"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/antonio-alexander/go-blog-cache/internal"
 	"github.com/antonio-alexander/go-blog-cache/internal/cache"
@@ -14,6 +17,7 @@ import (
 	"github.com/antonio-alexander/go-stash/memory"
 	"github.com/antonio-alexander/go-stash/redis"
 
+	goredis "github.com/redis/go-redis/v9"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -60,9 +64,14 @@ func newCacheTest(cacheType string) *cacheTest {
 	cacheCounter := utilities.NewCounter()
 	switch cacheType {
 	case "memory":
-		employeeCache = cache.NewMemory()
+		employeeCache = cache.NewMemory(logger)
 	case "redis":
-		employeeCache = cache.NewRedis()
+		employeeCache = cache.NewRedis(logger)
+	case "tiered":
+		employeeCache = cache.NewTiered(logger, cacheCounter)
+	case "invalidator":
+		employeeCache = cache.NewInvalidator(logger,
+			cache.NewMemory(logger))
 	case "stash-memory":
 		employeeCache = cache.NewStash(logger, cacheCounter,
 			memory.New())
@@ -156,6 +165,102 @@ func (c *cacheTest) TestCache(t *testing.T) {
 	employeeRead, err = c.EmployeeRead(ctx, employees[1].EmpNo)
 	assert.NotNil(t, err)
 	assert.Nil(t, employeeRead)
+
+	// write a search that doesn't include employee [1]
+	searchPartial := data.EmployeeSearch{EmpNos: []int64{employees[0].EmpNo, employees[2].EmpNo}}
+	err = c.EmployeesWrite(ctx, searchPartial, employees[0], employees[2])
+	assert.Nil(t, err)
+
+	// re-write employee [1] and validate that the search that included
+	// it was invalidated (rather than re-assembled), but the search that
+	// didn't include it is still cached
+	err = c.EmployeesWrite(ctx, data.EmployeeSearch{}, employees[1])
+	assert.Nil(t, err)
+	employeesRead, err = c.EmployeesRead(ctx, search)
+	assert.NotNil(t, err)
+	assert.Nil(t, employeesRead)
+	employeesRead, err = c.EmployeesRead(ctx, searchPartial)
+	assert.Nil(t, err)
+	assert.Len(t, employeesRead, 2)
+
+	// read the cached searches and validate that the criteria of
+	// the search is stored alongside it
+	searchPartialKey, err := searchPartial.ToKey()
+	assert.Nil(t, err)
+	searches, err := c.EmployeeSearchesRead(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, searchPartial, searches[searchPartialKey])
+
+	// delete the search and validate that it's no longer cached
+	err = c.EmployeeSearchesDelete(ctx, searchPartialKey)
+	assert.Nil(t, err)
+	searches, err = c.EmployeeSearchesRead(ctx)
+	assert.Nil(t, err)
+	assert.NotContains(t, searches, searchPartialKey)
+	employeesRead, err = c.EmployeesRead(ctx, searchPartial)
+	assert.NotNil(t, err)
+	assert.Nil(t, employeesRead)
+}
+
+// TestNotFound expects not found to be enabled with a ttl of one second
+// and in progress to be disabled
+func (c *cacheTest) TestNotFound(t *testing.T) {
+	ctx := context.TODO()
+	err := c.cache.Clear(ctx)
+	assert.Nil(t, err)
+
+	//write a search that found nothing and validate that the search
+	// and its employees are read as not found
+	search := data.EmployeeSearch{EmpNos: []int64{1, 2}}
+	searchKey, err := search.ToKey()
+	assert.Nil(t, err)
+	err = c.EmployeesNotFoundWrite(ctx, search, 1, 2)
+	assert.Nil(t, err)
+	_, err = c.EmployeeRead(ctx, 1)
+	assert.Equal(t, cache.ErrEmployeeNotFoundCached, err)
+	_, err = c.EmployeesRead(ctx, search)
+	assert.Equal(t, cache.ErrEmployeeNotFoundCached, err)
+	searches, err := c.EmployeeSearchesRead(ctx)
+	assert.Nil(t, err)
+	assert.Contains(t, searches, searchKey)
+
+	//validate that writing the employees clears not found
+	employees := []*data.Employee{
+		{EmpNo: 1, FirstName: internal.GenerateId()},
+		{EmpNo: 2, FirstName: internal.GenerateId()},
+	}
+	err = c.EmployeesWrite(ctx, search, employees...)
+	assert.Nil(t, err)
+	employeeRead, err := c.EmployeeRead(ctx, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, employees[0], employeeRead)
+	employeesRead, err := c.EmployeesRead(ctx, search)
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, employees, employeesRead)
+
+	//validate that deleting an employee (or a search) clears not found
+	search = data.EmployeeSearch{EmpNos: []int64{3}}
+	searchKey, err = search.ToKey()
+	assert.Nil(t, err)
+	err = c.EmployeesNotFoundWrite(ctx, search, 3)
+	assert.Nil(t, err)
+	err = c.EmployeesDelete(ctx, 3)
+	assert.Nil(t, err)
+	_, err = c.EmployeeRead(ctx, 3)
+	assert.Equal(t, cache.ErrEmployeeNotCached, err)
+	err = c.EmployeeSearchesDelete(ctx, searchKey)
+	assert.Nil(t, err)
+	_, err = c.EmployeesRead(ctx, search)
+	assert.Equal(t, cache.ErrEmployeeSearchNotCached, err)
+
+	//validate that not found expires
+	err = c.EmployeesNotFoundWrite(ctx, search, 3)
+	assert.Nil(t, err)
+	time.Sleep(2500 * time.Millisecond)
+	_, err = c.EmployeeRead(ctx, 3)
+	assert.Equal(t, cache.ErrEmployeeNotCached, err)
+	_, err = c.EmployeesRead(ctx, search)
+	assert.Equal(t, cache.ErrEmployeeSearchNotCached, err)
 }
 
 func testCache(t *testing.T, cacheType string) {
@@ -178,14 +283,1380 @@ func testCache(t *testing.T, cacheType string) {
 	t.Run("Cache", c.TestCache)
 }
 
+// newTestRedis will configure and open a redis cache with the envs and
+// the given overrides, the cache is closed once the test completes
+func newTestRedis(t *testing.T, overrides map[string]string) interface {
+	internal.Configurer
+	internal.Opener
+	internal.Clearer
+	cache.Cache
+	cache.Inspector
+	cache.Versioner
+	cache.Waiter
+} {
+	ctx := context.TODO()
+	c := cache.NewRedis(utilities.NewLogger())
+	redisEnvs := make(map[string]string)
+	for key, value := range envs {
+		redisEnvs[key] = value
+	}
+	for key, value := range overrides {
+		redisEnvs[key] = value
+	}
+	err := c.Configure(redisEnvs)
+	if !assert.Nil(t, err) {
+		assert.FailNow(t, "unable to configure cache")
+	}
+	err = c.Open(ctx)
+	if !assert.Nil(t, err) {
+		assert.FailNow(t, "unable to open cache")
+	}
+	t.Cleanup(func() {
+		if err := c.Close(ctx); err != nil {
+			t.Logf("error while closing cache: %s", err)
+		}
+	})
+	return c
+}
+
 func TestCacheMemory(t *testing.T) {
 	testCache(t, "memory")
 }
 
+func TestCacheMemoryEviction(t *testing.T) {
+	ctx := context.TODO()
+	c := cache.NewMemory(utilities.NewLogger())
+	err := c.Configure(map[string]string{
+		"CACHE_MAX_ENTRIES":     "4",
+		"CACHE_EVICTION_POLICY": "least_recently_used",
+	})
+	if !assert.Nil(t, err) {
+		assert.FailNow(t, "unable to configure cache")
+	}
+	err = c.Open(ctx)
+	if !assert.Nil(t, err) {
+		assert.FailNow(t, "unable to open cache")
+	}
+	defer func() {
+		if err := c.Close(ctx); err != nil {
+			t.Logf("error while closing cache: %s", err)
+		}
+	}()
+
+	//write employees, each write caches the employee and its search
+	employees := []*data.Employee{
+		{EmpNo: 1, FirstName: internal.GenerateId()},
+		{EmpNo: 2, FirstName: internal.GenerateId()},
+		{EmpNo: 3, FirstName: internal.GenerateId()},
+	}
+	searches := make([]data.EmployeeSearch, 0, len(employees))
+	for _, employee := range employees {
+		searches = append(searches, data.EmployeeSearch{
+			EmpNos: []int64{employee.EmpNo},
+		})
+	}
+	err = c.EmployeesWrite(ctx, searches[0], employees[0])
+	assert.Nil(t, err)
+	err = c.EmployeesWrite(ctx, searches[1], employees[1])
+	assert.Nil(t, err)
+
+	//read employee[0] so it's the most recently used
+	employeeRead, err := c.EmployeeRead(ctx, employees[0].EmpNo)
+	assert.Nil(t, err)
+	assert.Equal(t, employees[0], employeeRead)
+
+	//write employee[2], this should exceed the max entries
+	err = c.EmployeesWrite(ctx, searches[2], employees[2])
+	assert.Nil(t, err)
+
+	//validate that the least recently used entries were evicted
+	_, err = c.EmployeesRead(ctx, searches[0])
+	assert.NotNil(t, err)
+	employeeRead, err = c.EmployeeRead(ctx, employees[0].EmpNo)
+	assert.Nil(t, err)
+	assert.Equal(t, employees[0], employeeRead)
+	employeeRead, err = c.EmployeeRead(ctx, employees[2].EmpNo)
+	assert.Nil(t, err)
+	assert.Equal(t, employees[2], employeeRead)
+	employeesRead, err := c.EmployeesRead(ctx, searches[2])
+	assert.Nil(t, err)
+	assert.Len(t, employeesRead, 1)
+
+	//validate that an unsupported eviction policy can't be configured
+	err = cache.NewMemory().Configure(map[string]string{
+		"CACHE_EVICTION_POLICY": "most_recently_used",
+	})
+	assert.NotNil(t, err)
+
+	//validate which sleep is evicted by each policy; sleeps[0] and
+	// sleeps[1] are written and sleeps[0] is read twice and sleeps[1]
+	// once before sleeps[2] is written and exceeds the max entries
+	for policy, evicted := range map[string]int{
+		"least_recently_used":   0,
+		"least_frequently_used": 2,
+		"first_in_first_out":    0,
+	} {
+		t.Run(policy, func(t *testing.T) {
+			c := cache.NewMemory(utilities.NewLogger())
+			err := c.Configure(map[string]string{
+				"CACHE_MAX_ENTRIES":     "2",
+				"CACHE_EVICTION_POLICY": policy,
+			})
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to configure cache")
+			}
+			err = c.Open(ctx)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to open cache")
+			}
+			defer func() {
+				if err := c.Close(ctx); err != nil {
+					t.Logf("error while closing cache: %s", err)
+				}
+			}()
+
+			sleeps := []*data.Sleep{
+				{Id: internal.GenerateId(), Duration: 1},
+				{Id: internal.GenerateId(), Duration: 1},
+				{Id: internal.GenerateId(), Duration: 1},
+			}
+			for _, sleep := range sleeps[:2] {
+				err := c.SleepWrite(ctx, sleep)
+				assert.Nil(t, err)
+				time.Sleep(time.Millisecond)
+			}
+			for _, sleep := range []*data.Sleep{sleeps[0], sleeps[0], sleeps[1]} {
+				_, err := c.SleepRead(ctx, sleep.Id)
+				assert.Nil(t, err)
+				time.Sleep(time.Millisecond)
+			}
+			err = c.SleepWrite(ctx, sleeps[2])
+			assert.Nil(t, err)
+			for i, sleep := range sleeps {
+				_, err := c.SleepRead(ctx, sleep.Id)
+				if i == evicted {
+					assert.NotNil(t, err, "sleep %d should be evicted", i)
+					continue
+				}
+				assert.Nil(t, err, "sleep %d shouldn't be evicted", i)
+			}
+		})
+	}
+}
+
+func TestCacheMemorySnapshot(t *testing.T) {
+	ctx := context.TODO()
+	snapshotFile := filepath.Join(t.TempDir(), "snapshot.json")
+	snapshotEnvs := map[string]string{
+		"CACHE_TTL":           "1",
+		"CACHE_SNAPSHOT_FILE": snapshotFile,
+	}
+	openFx := func() interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+	} {
+		c := cache.NewMemory(utilities.NewLogger())
+		err := c.Configure(snapshotEnvs)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to configure cache")
+		}
+		err = c.Open(ctx)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to open cache")
+		}
+		return c
+	}
+
+	//write an employee, search and sleep then close the cache
+	// to write the snapshot
+	employee := &data.Employee{EmpNo: 1, FirstName: internal.GenerateId()}
+	search := data.EmployeeSearch{EmpNos: []int64{employee.EmpNo}}
+	sleep := &data.Sleep{Id: internal.GenerateId()}
+	c := openFx()
+	err := c.EmployeesWrite(ctx, search, employee)
+	assert.Nil(t, err)
+	err = c.SleepWrite(ctx, sleep)
+	assert.Nil(t, err)
+	err = c.Close(ctx)
+	assert.Nil(t, err)
+	assert.FileExists(t, snapshotFile)
+
+	//re-open the cache and validate that the entries were restored
+	c = openFx()
+	employeeRead, err := c.EmployeeRead(ctx, employee.EmpNo)
+	assert.Nil(t, err)
+	assert.Equal(t, employee, employeeRead)
+	employeesRead, err := c.EmployeesRead(ctx, search)
+	assert.Nil(t, err)
+	assert.Equal(t, []*data.Employee{employee}, employeesRead)
+	sleepRead, err := c.SleepRead(ctx, sleep.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, sleep, sleepRead)
+	err = c.Close(ctx)
+	assert.Nil(t, err)
+
+	//wait for the entries to expire, re-open the cache and validate
+	// that the expired entries weren't restored
+	time.Sleep(1500 * time.Millisecond)
+	c = openFx()
+	_, err = c.EmployeeRead(ctx, employee.EmpNo)
+	assert.NotNil(t, err)
+	_, err = c.SleepRead(ctx, sleep.Id)
+	assert.NotNil(t, err)
+	err = c.Close(ctx)
+	assert.Nil(t, err)
+
+	//write a snapshot with an unsupported version and validate
+	// that it's rejected (but the cache still opens)
+	err = os.WriteFile(snapshotFile, []byte(`{"version":0,"employees":[{"employee":{"emp_no":1}}]}`), 0644)
+	assert.Nil(t, err)
+	c = openFx()
+	_, err = c.EmployeeRead(ctx, employee.EmpNo)
+	assert.NotNil(t, err)
+	err = c.Close(ctx)
+	assert.Nil(t, err)
+}
+
 func TestCacheRedis(t *testing.T) {
 	testCache(t, "redis")
 }
 
+func TestCacheRedisTopology(t *testing.T) {
+	ctx := context.TODO()
+	newEnvs := func(mode string) map[string]string {
+		topologyEnvs := make(map[string]string)
+		for key, value := range envs {
+			topologyEnvs[key] = value
+		}
+		topologyEnvs["REDIS_MODE"] = mode
+		return topologyEnvs
+	}
+
+	//validate that an unsupported mode is rejected and that sentinel
+	// and cluster can't be opened without their addresses
+	err := cache.NewRedis().Configure(newEnvs("bogus"))
+	assert.NotNil(t, err)
+	for _, mode := range []string{"sentinel", "cluster"} {
+		c := cache.NewRedis()
+		topologyEnvs := newEnvs(mode)
+		topologyEnvs["REDIS_SENTINEL_ADDRESSES"] = ""
+		topologyEnvs["REDIS_CLUSTER_ADDRESSES"] = ""
+		err = c.Configure(topologyEnvs)
+		assert.Nil(t, err)
+		err = c.Open(ctx)
+		assert.NotNil(t, err)
+	}
+
+	//KIM: sentinel and cluster deployments are only tested if their
+	// addresses are provided (e.g. REDIS_CLUSTER_ADDRESSES)
+	for mode, env := range map[string]string{
+		"sentinel": "REDIS_SENTINEL_ADDRESSES",
+		"cluster":  "REDIS_CLUSTER_ADDRESSES",
+	} {
+		t.Run(mode, func(t *testing.T) {
+			if envs[env] == "" {
+				t.Skipf("%s not set", env)
+			}
+			c := newCacheTest("redis")
+			err := c.cache.Configure(newEnvs(mode))
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to configure cache")
+			}
+			err = c.cache.Open(ctx)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to open cache")
+			}
+			defer func() {
+				if err := c.cache.Close(ctx); err != nil {
+					t.Logf("error while closing cache: %s", err)
+				}
+			}()
+			t.Run("Cache", c.TestCache)
+		})
+	}
+}
+
+func TestCacheRedisTls(t *testing.T) {
+	ctx := context.TODO()
+	newEnvs := func(tlsEnvs map[string]string) map[string]string {
+		redisEnvs := make(map[string]string)
+		for key, value := range envs {
+			redisEnvs[key] = value
+		}
+		for key, value := range tlsEnvs {
+			redisEnvs[key] = value
+		}
+		return redisEnvs
+	}
+
+	//validate that a missing ca file (or a certificate without a key)
+	// prevents the cache from opening and that tls against a plain
+	// text redis fails rather than falling back to plain text
+	for _, tlsEnvs := range []map[string]string{
+		{"REDIS_TLS_ENABLED": "true", "REDIS_TLS_CA_FILE": filepath.Join(t.TempDir(), "ca.crt")},
+		{"REDIS_TLS_ENABLED": "true", "REDIS_TLS_CRT_FILE": filepath.Join(t.TempDir(), "tls.crt")},
+		{"REDIS_TLS_ENABLED": "true", "REDIS_TLS_SERVER_NAME": "localhost"},
+	} {
+		for _, c := range []interface {
+			internal.Configurer
+			internal.Opener
+		}{
+			cache.NewRedis(),
+			cache.NewInvalidator(cache.NewMemory()),
+		} {
+			err := c.Configure(newEnvs(tlsEnvs))
+			assert.Nil(t, err)
+			err = c.Open(ctx)
+			assert.NotNil(t, err)
+		}
+	}
+
+	//validate that the redis stash rejects tls and acl authentication
+	// since it can't be configured with them
+	for _, tlsEnvs := range []map[string]string{
+		{"REDIS_TLS_ENABLED": "true"},
+		{"REDIS_USERNAME": "go-blog-cache"},
+	} {
+		err := cache.NewStash(redis.New()).Configure(newEnvs(tlsEnvs))
+		assert.NotNil(t, err)
+		err = cache.NewStash(memory.New()).Configure(newEnvs(tlsEnvs))
+		assert.Nil(t, err)
+	}
+}
+
+func TestCacheRedisTTL(t *testing.T) {
+	ctx := context.TODO()
+	c := newTestRedis(t, map[string]string{
+		"CACHE_TTL": "2",
+	})
+
+	//write two employees, one second apart
+	employees := []*data.Employee{
+		{EmpNo: 1, FirstName: internal.GenerateId()},
+		{EmpNo: 2, FirstName: internal.GenerateId()},
+	}
+	err := c.EmployeesWrite(ctx, data.EmployeeSearch{}, employees[0])
+	assert.Nil(t, err)
+	time.Sleep(time.Second)
+	err = c.EmployeesWrite(ctx, data.EmployeeSearch{}, employees[1])
+	assert.Nil(t, err)
+
+	//validate that each employee expires independently
+	time.Sleep(1500 * time.Millisecond)
+	_, err = c.EmployeeRead(ctx, employees[0].EmpNo)
+	assert.NotNil(t, err)
+	employeeRead, err := c.EmployeeRead(ctx, employees[1].EmpNo)
+	assert.Nil(t, err)
+	assert.Equal(t, employees[1], employeeRead)
+}
+
+func TestCacheRedisCodec(t *testing.T) {
+	ctx := context.TODO()
+
+	//validate that an unsupported codec can't be configured
+	err := cache.NewRedis().Configure(map[string]string{"CACHE_CODEC": "xml"})
+	assert.NotNil(t, err)
+
+	//validate that entries written with any codec can be read by
+	// a cache configured with a different codec (e.g. during a
+	// rolling deploy)
+	reader := newTestRedis(t, map[string]string{
+		"CACHE_TTL":   "60",
+		"CACHE_CODEC": "json",
+	})
+	for _, codec := range []string{"json", "gob", "msgpack", "json_gzip"} {
+		t.Run(codec, func(t *testing.T) {
+			writer := newTestRedis(t, map[string]string{
+				"CACHE_TTL":   "60",
+				"CACHE_CODEC": codec,
+			})
+			err := writer.Clear(ctx)
+			assert.Nil(t, err)
+
+			employee := &data.Employee{EmpNo: 1, FirstName: internal.GenerateId(),
+				LastName: internal.GenerateId(), HireDate: time.Now().Unix()}
+			search := data.EmployeeSearch{EmpNos: []int64{employee.EmpNo}}
+			sleep := &data.Sleep{Id: internal.GenerateId(), Duration: 1}
+			err = writer.EmployeesWrite(ctx, search, employee)
+			assert.Nil(t, err)
+			err = writer.SleepWrite(ctx, sleep)
+			assert.Nil(t, err)
+			for _, c := range []cache.Cache{writer, reader} {
+				employeeRead, err := c.EmployeeRead(ctx, employee.EmpNo)
+				assert.Nil(t, err)
+				assert.Equal(t, employee, employeeRead)
+				employeesRead, err := c.EmployeesRead(ctx, search)
+				assert.Nil(t, err)
+				assert.Equal(t, []*data.Employee{employee}, employeesRead)
+				sleepRead, err := c.SleepRead(ctx, sleep.Id)
+				assert.Nil(t, err)
+				assert.Equal(t, sleep, sleepRead)
+			}
+			entry, err := reader.EntryRead(ctx, data.CacheEntryTypeEmployee, "1")
+			if assert.Nil(t, err) {
+				assert.Equal(t, codec, entry.Format)
+				employeeRead := &data.Employee{}
+				err = employeeRead.UnmarshalBinary(entry.Value)
+				assert.Nil(t, err)
+				assert.Equal(t, employee, employeeRead)
+			}
+		})
+	}
+
+	//validate that entries written before the header was introduced
+	// can still be read
+	redisClient := goredis.NewClient(&goredis.Options{
+		Addr: envs["REDIS_ADDRESS"] + ":" + envs["REDIS_PORT"],
+	})
+	defer redisClient.Close()
+	employee := &data.Employee{EmpNo: 2, FirstName: internal.GenerateId()}
+	err = redisClient.Set(ctx, "employees:2", fmt.Sprintf(`{"cached_at":%d,"ttl":%d,"value":{"emp_no":2,"first_name":"%s"}}`,
+		time.Now().UnixNano(), time.Minute, employee.FirstName), time.Minute).Err()
+	assert.Nil(t, err)
+	employeeRead, err := reader.EmployeeRead(ctx, employee.EmpNo)
+	assert.Nil(t, err)
+	assert.Equal(t, employee, employeeRead)
+}
+
+func TestCacheRedisMutex(t *testing.T) {
+	ctx := context.TODO()
+	c := newTestRedis(t, map[string]string{
+		"CACHE_REDIS_MUTEX_EXPIRATION": "1",
+	})
+	locker, ok := c.(cache.Locker)
+	if !assert.True(t, ok) {
+		assert.FailNow(t, "redis cache doesn't implement locker")
+	}
+
+	//lock the mutex and hold it longer than its expiration
+	key := internal.GenerateId()
+	mutex1, mutex2 := locker.NewMutex(key), locker.NewMutex(key)
+	fencingToken1, err := mutex1.Lock(ctx)
+	assert.Nil(t, err)
+	time.Sleep(2 * time.Second)
+
+	//validate that the mutex is still held and can't be unlocked
+	// by anyone other than its holder
+	ctxTimeout, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	_, err = mutex2.Lock(ctxTimeout)
+	assert.ErrorIs(t, err, cache.ErrMutexNotAcquired)
+	err = mutex2.Unlock(ctx)
+	assert.ErrorIs(t, err, cache.ErrMutexNotHeld)
+	err = mutex1.Unlock(ctx)
+	assert.Nil(t, err)
+
+	//validate that fencing tokens increase and that stale fencing
+	// tokens are rejected
+	fencingToken2, err := mutex2.Lock(ctx)
+	assert.Nil(t, err)
+	assert.Greater(t, fencingToken2, fencingToken1)
+	sleep := &data.Sleep{Id: internal.GenerateId()}
+	err = c.SleepWrite(cache.CtxWithFencingToken(ctx, fencingToken2), sleep)
+	assert.Nil(t, err)
+	err = c.SleepWrite(cache.CtxWithFencingToken(ctx, fencingToken1), sleep)
+	assert.ErrorIs(t, err, cache.ErrFencingTokenStale)
+	err = mutex2.Unlock(ctx)
+	assert.Nil(t, err)
+
+	//validate that invalid expirations and retry intervals fall back
+	// to their defaults rather than panicking on lock
+	for _, value := range []string{"0", "", "-1", "invalid"} {
+		c := newTestRedis(t, map[string]string{
+			"CACHE_REDIS_MUTEX_EXPIRATION": value,
+			"REDIS_MUTEX_RETRY_INTERVAL":   value,
+		})
+		mutex := c.(cache.Locker).NewMutex(internal.GenerateId())
+		_, err = mutex.Lock(ctx)
+		assert.Nil(t, err)
+		err = mutex.Unlock(ctx)
+		assert.Nil(t, err)
+	}
+}
+
+func TestCacheRedisBatch(t *testing.T) {
+	ctx := context.TODO()
+	c := newTestRedis(t, map[string]string{
+		"CACHE_ENABLE_IN_PROGRESS": "false",
+	})
+	err := c.Clear(ctx)
+	assert.Nil(t, err)
+
+	//write a large search and validate that its employees are read
+	// back in the same order
+	var employees []*data.Employee
+	var search data.EmployeeSearch
+	for empNo := int64(500); empNo > 0; empNo-- {
+		employees = append(employees, &data.Employee{EmpNo: empNo, FirstName: internal.GenerateId()})
+		search.EmpNos = append(search.EmpNos, empNo)
+	}
+	err = c.EmployeesWrite(ctx, search, employees...)
+	assert.Nil(t, err)
+	employeesRead, err := c.EmployeesRead(ctx, search)
+	assert.Nil(t, err)
+	assert.Equal(t, employees, employeesRead)
+
+	//validate that a write is all or nothing, if one employee is rejected
+	// neither the other employees nor the search are written
+	employee1 := &data.Employee{EmpNo: 1001, FirstName: internal.GenerateId()}
+	employee2 := &data.Employee{EmpNo: 1002, FirstName: internal.GenerateId()}
+	search = data.EmployeeSearch{EmpNos: []int64{employee1.EmpNo, employee2.EmpNo}}
+	err = c.EmployeesWrite(cache.CtxWithFencingToken(ctx, 2), data.EmployeeSearch{}, employee1)
+	assert.Nil(t, err)
+	err = c.EmployeesWrite(cache.CtxWithFencingToken(ctx, 1), search, employee1, employee2)
+	assert.ErrorIs(t, err, cache.ErrFencingTokenStale)
+	_, err = c.EmployeeRead(ctx, employee2.EmpNo)
+	assert.ErrorIs(t, err, cache.ErrEmployeeNotCached)
+	_, err = c.EmployeesRead(ctx, search)
+	assert.ErrorIs(t, err, cache.ErrEmployeeSearchNotCached)
+}
+
+func TestCacheRedisReadOrClaim(t *testing.T) {
+	ctx := context.TODO()
+	c := newTestRedis(t, map[string]string{
+		"CACHE_ENABLE_IN_PROGRESS":       "true",
+		"CACHE_SET_READ_TTL":             "10",
+		"CACHE_PRUNE_INTERVAL":           "1",
+		"CACHE_NOT_FOUND_ENABLED":        "true",
+		"CACHE_NOT_FOUND_TTL":            "1",
+		"CACHE_NOT_FOUND_PRUNE_INTERVAL": "60",
+	})
+	err := c.Clear(ctx)
+	assert.Nil(t, err)
+
+	//validate that a read that finds nothing cached as not found is
+	// granted a lease and that an employee cached as not found is
+	// read as not found (rather than in progress)
+	search := data.EmployeeSearch{EmpNos: []int64{1}}
+	_, err = c.EmployeeRead(ctx, 1)
+	assert.Equal(t, cache.ErrEmployeeReadSet, err)
+	err = c.EmployeesNotFoundWrite(ctx, search, 1)
+	assert.Nil(t, err)
+	_, err = c.EmployeeRead(ctx, 1)
+	assert.Equal(t, cache.ErrEmployeeNotFoundCached, err)
+	_, err = c.EmployeesRead(ctx, search)
+	assert.Equal(t, cache.ErrEmployeeNotFoundCached, err)
+
+	//validate that not found expires when read, even if it hasn't
+	// been pruned
+	time.Sleep(1500 * time.Millisecond)
+	_, err = c.EmployeeRead(ctx, 1)
+	assert.Equal(t, cache.ErrEmployeeReadSet, err)
+	_, err = c.EmployeesRead(ctx, search)
+	assert.Equal(t, cache.ErrEmployeesSearchSet, err)
+}
+
+func TestCacheRedisMarkers(t *testing.T) {
+	ctx := context.TODO()
+	c := newTestRedis(t, map[string]string{
+		"CACHE_ENABLE_IN_PROGRESS":       "true",
+		"CACHE_SET_READ_TTL":             "1",
+		"CACHE_PRUNE_INTERVAL":           "1",
+		"CACHE_NOT_FOUND_ENABLED":        "true",
+		"CACHE_NOT_FOUND_TTL":            "1",
+		"CACHE_NOT_FOUND_PRUNE_INTERVAL": "1",
+	})
+	err := c.Clear(ctx)
+	assert.Nil(t, err)
+	redisClient := goredis.NewClient(&goredis.Options{
+		Addr: envs["REDIS_ADDRESS"] + ":" + envs["REDIS_PORT"],
+	})
+	defer redisClient.Close()
+	markersFx := func(hashKey string) (int64, int64) {
+		nFields, err := redisClient.HLen(ctx, hashKey).Result()
+		assert.Nil(t, err)
+		nTracked, err := redisClient.ZCard(ctx, "{"+hashKey+"}:expiry").Result()
+		assert.Nil(t, err)
+		return nFields, nTracked
+	}
+
+	//set an in progress and not found markers and validate that
+	// when each marker was set is tracked
+	search := data.EmployeeSearch{EmpNos: []int64{2}}
+	searchKey, err := search.ToKey()
+	assert.Nil(t, err)
+	_, err = c.EmployeeRead(ctx, 1)
+	assert.Equal(t, cache.ErrEmployeeReadSet, err)
+	err = c.EmployeesNotFoundWrite(ctx, search, 2)
+	assert.Nil(t, err)
+	nFields, nTracked := markersFx("in_progress_employees")
+	assert.Equal(t, int64(1), nFields)
+	assert.Equal(t, int64(1), nTracked)
+	nFields, nTracked = markersFx("not_found_employees")
+	assert.Equal(t, int64(2), nFields)
+	assert.Equal(t, int64(2), nTracked)
+	searches, err := c.EmployeeSearchesRead(ctx)
+	assert.Nil(t, err)
+	assert.Contains(t, searches, searchKey)
+
+	//validate that the markers are pruned once they expire
+	time.Sleep(2500 * time.Millisecond)
+	for _, hashKey := range []string{"in_progress_employees", "not_found_employees"} {
+		nFields, nTracked = markersFx(hashKey)
+		assert.Zero(t, nFields)
+		assert.Zero(t, nTracked)
+	}
+	searches, err = c.EmployeeSearchesRead(ctx)
+	assert.Nil(t, err)
+	assert.NotContains(t, searches, searchKey)
+}
+
+func TestCacheRedisKeyPrefix(t *testing.T) {
+	ctx := context.TODO()
+	newEnvs := func(keyPrefix string) map[string]string {
+		return map[string]string{
+			"CACHE_KEY_PREFIX":               keyPrefix,
+			"CACHE_NOT_FOUND_ENABLED":        "true",
+			"CACHE_NOT_FOUND_TTL":            "10",
+			"CACHE_NOT_FOUND_PRUNE_INTERVAL": "1",
+		}
+	}
+
+	//validate that a prefix with a hash tag (or glob characters)
+	// is rejected
+	for _, keyPrefix := range []string{"{app}", "app*"} {
+		err := cache.NewRedis().Configure(map[string]string{
+			"CACHE_KEY_PREFIX": keyPrefix,
+		})
+		assert.NotNil(t, err)
+	}
+
+	//create two caches with different prefixes that share a database
+	c1, c2 := newTestRedis(t, newEnvs("app1")), newTestRedis(t, newEnvs("app2"))
+	err := c1.Clear(ctx)
+	assert.Nil(t, err)
+	err = c2.Clear(ctx)
+	assert.Nil(t, err)
+
+	//write an employee, search and not found to the first cache and
+	// validate that they can't be read from the second cache
+	employee := &data.Employee{EmpNo: 1, FirstName: internal.GenerateId()}
+	search := data.EmployeeSearch{EmpNos: []int64{employee.EmpNo}}
+	searchNotFound := data.EmployeeSearch{EmpNos: []int64{2}}
+	err = c1.EmployeesWrite(ctx, search, employee)
+	assert.Nil(t, err)
+	err = c1.EmployeesNotFoundWrite(ctx, searchNotFound, 2)
+	assert.Nil(t, err)
+	_, err = c2.EmployeeRead(ctx, employee.EmpNo)
+	assert.Equal(t, cache.ErrEmployeeNotCached, err)
+	_, err = c2.EmployeesRead(ctx, search)
+	assert.Equal(t, cache.ErrEmployeeSearchNotCached, err)
+	_, err = c2.EmployeeRead(ctx, 2)
+	assert.Equal(t, cache.ErrEmployeeNotCached, err)
+	searches, err := c2.EmployeeSearchesRead(ctx)
+	assert.Nil(t, err)
+	assert.Len(t, searches, 0)
+
+	//validate that the keys of the first cache are prefixed
+	redisClient := goredis.NewClient(&goredis.Options{
+		Addr: envs["REDIS_ADDRESS"] + ":" + envs["REDIS_PORT"],
+	})
+	defer redisClient.Close()
+	keys, err := redisClient.Keys(ctx, "app1:*").Result()
+	assert.Nil(t, err)
+	assert.Contains(t, keys, "app1:employees:1")
+	assert.Contains(t, keys, "app1:not_found_employees")
+
+	//clear the second cache and validate that the first cache's
+	// entries weren't cleared (or invalidated)
+	err = c2.Clear(ctx)
+	assert.Nil(t, err)
+	employeeRead, err := c1.EmployeeRead(ctx, employee.EmpNo)
+	assert.Nil(t, err)
+	assert.Equal(t, employee, employeeRead)
+	employeesRead, err := c1.EmployeesRead(ctx, search)
+	assert.Nil(t, err)
+	assert.Equal(t, []*data.Employee{employee}, employeesRead)
+	_, err = c1.EmployeeRead(ctx, 2)
+	assert.Equal(t, cache.ErrEmployeeNotFoundCached, err)
+
+	//clear the first cache and validate that its keys were deleted
+	err = c1.Clear(ctx)
+	assert.Nil(t, err)
+	_, err = c1.EmployeeRead(ctx, employee.EmpNo)
+	assert.Equal(t, cache.ErrEmployeeNotCached, err)
+	keys, err = redisClient.Keys(ctx, "app1:employee*").Result()
+	assert.Nil(t, err)
+	assert.Len(t, keys, 0)
+
+	//validate that the invalidator rejects the same prefixes
+	for _, keyPrefix := range []string{"{app}", "app*"} {
+		err := cache.NewInvalidator(cache.NewMemory()).Configure(map[string]string{
+			"CACHE_KEY_PREFIX": keyPrefix,
+		})
+		assert.NotNil(t, err)
+	}
+
+	//create two invalidators that share a prefix and one with a
+	// different prefix, then write the same employees to each
+	employees := []*data.Employee{
+		{EmpNo: 1, FirstName: internal.GenerateId()},
+		{EmpNo: 2, FirstName: internal.GenerateId()},
+	}
+	invalidators := make([]interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+	}, 0, 3)
+	for _, keyPrefix := range []string{"app1", "app1", "app2"} {
+		c := cache.NewInvalidator(utilities.NewLogger(),
+			cache.NewMemory(utilities.NewLogger()))
+		invalidatorEnvs := make(map[string]string)
+		for key, value := range envs {
+			invalidatorEnvs[key] = value
+		}
+		invalidatorEnvs["CACHE_KEY_PREFIX"] = keyPrefix
+		err := c.Configure(invalidatorEnvs)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to configure cache")
+		}
+		err = c.Open(ctx)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to open cache")
+		}
+		defer func() {
+			if err := c.Close(ctx); err != nil {
+				t.Logf("error while closing cache: %s", err)
+			}
+		}()
+		err = c.EmployeesWrite(ctx, data.EmployeeSearch{}, employees...)
+		assert.Nil(t, err)
+		invalidators = append(invalidators, c)
+	}
+
+	//clear the invalidator with the different prefix, then delete an
+	// employee from the first invalidator; once the second invalidator
+	// has evicted the deleted employee, validate that it didn't
+	// receive the clear
+	err = invalidators[2].Clear(ctx)
+	assert.Nil(t, err)
+	err = invalidators[0].EmployeesDelete(ctx, employees[1].EmpNo)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := invalidators[1].EmployeeRead(ctx, employees[1].EmpNo)
+		return err != nil
+	}, 5*time.Second, 100*time.Millisecond)
+	employeeRead, err = invalidators[1].EmployeeRead(ctx, employees[0].EmpNo)
+	assert.Nil(t, err)
+	assert.Equal(t, employees[0], employeeRead)
+}
+
+func TestCacheStale(t *testing.T) {
+	logger := utilities.NewLogger()
+	for cacheType, c := range map[string]interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+	}{
+		"memory": cache.NewMemory(logger),
+		"redis":  cache.NewRedis(logger),
+	} {
+		t.Run(cacheType, func(t *testing.T) {
+			ctx := context.TODO()
+			staleEnvs := make(map[string]string)
+			for key, value := range envs {
+				staleEnvs[key] = value
+			}
+			staleEnvs["CACHE_TTL"] = "1"
+			staleEnvs["CACHE_HARD_TTL"] = "3"
+			err := c.Configure(staleEnvs)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to configure cache")
+			}
+			err = c.Open(ctx)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to open cache")
+			}
+			defer func() {
+				if err := c.Close(ctx); err != nil {
+					t.Logf("error while closing cache: %s", err)
+				}
+			}()
+
+			//write employee and validate that it's not stale
+			employee := &data.Employee{EmpNo: 1, FirstName: internal.GenerateId()}
+			search := data.EmployeeSearch{EmpNos: []int64{employee.EmpNo}}
+			err = c.EmployeesWrite(ctx, search, employee)
+			assert.Nil(t, err)
+			employeeRead, err := c.EmployeeRead(ctx, employee.EmpNo)
+			assert.Nil(t, err)
+			assert.Equal(t, employee, employeeRead)
+
+			//wait past the soft ttl and validate that the stale
+			// employee is returned alongside a stale error
+			time.Sleep(1500 * time.Millisecond)
+			employeeRead, err = c.EmployeeRead(ctx, employee.EmpNo)
+			assert.ErrorIs(t, err, data.ErrStale)
+			assert.Equal(t, employee, employeeRead)
+			employeesRead, err := c.EmployeesRead(ctx, search)
+			assert.ErrorIs(t, err, data.ErrStale)
+			assert.Len(t, employeesRead, 1)
+
+			//wait past the hard ttl and validate that the employee
+			// is no longer cached
+			time.Sleep(3 * time.Second)
+			employeeRead, err = c.EmployeeRead(ctx, employee.EmpNo)
+			assert.NotNil(t, err)
+			assert.NotErrorIs(t, err, data.ErrStale)
+			assert.Nil(t, employeeRead)
+		})
+	}
+}
+
+func TestCacheEarlyExpiration(t *testing.T) {
+	logger := utilities.NewLogger()
+	for cacheType, c := range map[string]interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+	}{
+		"memory": cache.NewMemory(logger),
+		"redis":  cache.NewRedis(logger),
+	} {
+		t.Run(cacheType, func(t *testing.T) {
+			ctx := context.TODO()
+			earlyEnvs := make(map[string]string)
+			for key, value := range envs {
+				earlyEnvs[key] = value
+			}
+			earlyEnvs["CACHE_TTL"] = "60"
+			earlyEnvs["CACHE_HARD_TTL"] = "120"
+			earlyEnvs["CACHE_TTL_JITTER"] = "0.1"
+			earlyEnvs["CACHE_XFETCH_BETA"] = "1000000"
+			err := c.Configure(earlyEnvs)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to configure cache")
+			}
+			err = c.Open(ctx)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to open cache")
+			}
+			defer func() {
+				if err := c.Close(ctx); err != nil {
+					t.Logf("error while closing cache: %s", err)
+				}
+			}()
+
+			//write an employee without a fetch duration and validate
+			// that it won't be recomputed early
+			employee := &data.Employee{EmpNo: 1, FirstName: internal.GenerateId()}
+			err = c.EmployeesWrite(ctx, data.EmployeeSearch{}, employee)
+			assert.Nil(t, err)
+			employeeRead, err := c.EmployeeRead(ctx, employee.EmpNo)
+			assert.Nil(t, err)
+			assert.Equal(t, employee, employeeRead)
+
+			//write an employee with an (expensive) fetch duration and
+			// validate that it's recomputed early (read as due a refresh
+			// rather than stale) well before its ttl
+			ctxFetch := cache.CtxWithFetchDuration(ctx, time.Second)
+			err = c.EmployeesWrite(ctxFetch, data.EmployeeSearch{}, employee)
+			assert.Nil(t, err)
+			employeeRead, err = c.EmployeeRead(ctx, employee.EmpNo)
+			assert.ErrorIs(t, err, data.ErrRefresh)
+			assert.NotErrorIs(t, err, data.ErrStale)
+			assert.Equal(t, employee, employeeRead)
+		})
+	}
+}
+
+func TestCacheVersioning(t *testing.T) {
+	logger := utilities.NewLogger()
+	for cacheType, c := range map[string]interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+		cache.Versioner
+	}{
+		"memory": cache.NewMemory(logger),
+		"redis":  cache.NewRedis(logger),
+		"tiered": cache.NewTiered(logger),
+	} {
+		t.Run(cacheType, func(t *testing.T) {
+			ctx := context.TODO()
+			err := c.Configure(envs)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to configure cache")
+			}
+			err = c.Open(ctx)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to open cache")
+			}
+			defer func() {
+				if err := c.Close(ctx); err != nil {
+					t.Logf("error while closing cache: %s", err)
+				}
+			}()
+			err = c.Clear(ctx)
+			assert.Nil(t, err)
+			fillBegin := func() context.Context {
+				ctx, err := cache.FillBegin(ctx, c)
+				assert.Nil(t, err)
+				return ctx
+			}
+
+			//begin a fill, invalidate the employee and validate that the
+			// fill is rejected (and the employee isn't cached)
+			employee := &data.Employee{EmpNo: 1, FirstName: internal.GenerateId()}
+			search := data.EmployeeSearch{EmpNos: []int64{employee.EmpNo}}
+			fillCtx := fillBegin()
+			err = c.EmployeesDelete(ctx, employee.EmpNo)
+			assert.Nil(t, err)
+			err = c.EmployeesWrite(fillCtx, search, employee)
+			assert.ErrorIs(t, err, cache.ErrFillInvalidated)
+			_, err = c.EmployeeRead(ctx, employee.EmpNo)
+			assert.NotNil(t, err)
+			_, err = c.EmployeesRead(ctx, search)
+			assert.NotNil(t, err)
+
+			//validate that a fill that began after the invalidation
+			// (or without a generation) is accepted
+			err = c.EmployeesWrite(fillBegin(), search, employee)
+			assert.Nil(t, err)
+			employeeRead, err := c.EmployeeRead(ctx, employee.EmpNo)
+			assert.Nil(t, err)
+			assert.Equal(t, employee, employeeRead)
+			err = c.EmployeesWrite(ctx, search, employee)
+			assert.Nil(t, err)
+
+			//validate that invalidating a search rejects fills of the
+			// search, but not of other entries
+			searchKey, err := search.ToKey()
+			assert.Nil(t, err)
+			fillCtx = fillBegin()
+			err = c.EmployeeSearchesDelete(ctx, searchKey)
+			assert.Nil(t, err)
+			err = c.EmployeesWrite(fillCtx, search, employee)
+			assert.ErrorIs(t, err, cache.ErrFillInvalidated)
+			sleep := &data.Sleep{Id: internal.GenerateId(), Duration: 1}
+			err = c.SleepWrite(fillCtx, sleep)
+			assert.Nil(t, err)
+
+			//validate that invalidating a sleep rejects fills of the sleep
+			fillCtx = fillBegin()
+			err = c.SleepsDelete(ctx, sleep.Id)
+			assert.Nil(t, err)
+			err = c.SleepWrite(fillCtx, sleep)
+			assert.ErrorIs(t, err, cache.ErrFillInvalidated)
+			_, err = c.SleepRead(ctx, sleep.Id)
+			assert.NotNil(t, err)
+
+			//validate that clearing the cache rejects any fill in progress
+			fillCtx = fillBegin()
+			err = c.Clear(ctx)
+			assert.Nil(t, err)
+			err = c.SleepWrite(fillCtx, sleep)
+			assert.ErrorIs(t, err, cache.ErrFillInvalidated)
+		})
+	}
+}
+
+func TestCacheLease(t *testing.T) {
+	logger := utilities.NewLogger()
+	for cacheType, c := range map[string]interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+	}{
+		"memory":       cache.NewMemory(logger),
+		"redis":        cache.NewRedis(logger),
+		"stash-memory": cache.NewStash(logger, memory.New()),
+		"stash-redis":  cache.NewStash(logger, redis.New()),
+	} {
+		t.Run(cacheType, func(t *testing.T) {
+			ctx := context.TODO()
+			leaseEnvs := make(map[string]string)
+			for key, value := range envs {
+				leaseEnvs[key] = value
+			}
+			leaseEnvs["CACHE_ENABLE_IN_PROGRESS"] = "true"
+			leaseEnvs["CACHE_SET_READ_TTL"] = "1"
+			leaseEnvs["CACHE_PRUNE_INTERVAL"] = "1"
+			err := c.Configure(leaseEnvs)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to configure cache")
+			}
+			err = c.Open(ctx)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to open cache")
+			}
+			defer func() {
+				if err := c.Close(ctx); err != nil {
+					t.Logf("error while closing cache: %s", err)
+				}
+			}()
+			err = c.Clear(ctx)
+			assert.Nil(t, err)
+
+			//read an employee that isn't cached and validate that a lease
+			// is granted to the first read, but not the second
+			employee := &data.Employee{EmpNo: 1, FirstName: internal.GenerateId()}
+			leaseCtx := cache.CtxWithLease(ctx)
+			_, err = c.EmployeeRead(leaseCtx, employee.EmpNo)
+			assert.ErrorIs(t, err, cache.ErrEmployeeReadSet)
+			token, ok := cache.LeaseFromCtx(leaseCtx)
+			assert.True(t, ok)
+			assert.NotEmpty(t, token)
+			otherCtx := cache.CtxWithLease(ctx)
+			_, err = c.EmployeeRead(otherCtx, employee.EmpNo)
+			assert.Equal(t, cache.ErrEmployeeReadAlreadySet, err)
+			_, ok = cache.LeaseFromCtx(otherCtx)
+			assert.False(t, ok)
+
+			//validate that a write without a lease (e.g. a refresh) is
+			// accepted and that a write with the lease is accepted
+			err = c.EmployeesWrite(otherCtx, data.EmployeeSearch{}, employee)
+			assert.Nil(t, err)
+			err = c.EmployeesDelete(ctx, employee.EmpNo)
+			assert.Nil(t, err)
+			leaseCtx = cache.CtxWithLease(ctx)
+			_, err = c.EmployeeRead(leaseCtx, employee.EmpNo)
+			assert.Equal(t, cache.ErrEmployeeReadSet, err)
+			err = c.EmployeesWrite(leaseCtx, data.EmployeeSearch{}, employee)
+			assert.Nil(t, err)
+			employeeRead, err := c.EmployeeRead(ctx, employee.EmpNo)
+			assert.Nil(t, err)
+			assert.Equal(t, employee, employeeRead)
+
+			//validate that an expired lease is granted to another read and
+			// that the write of the original lease holder is rejected
+			search := data.EmployeeSearch{EmpNos: []int64{2}}
+			leaseCtx = cache.CtxWithLease(ctx)
+			_, err = c.EmployeesRead(leaseCtx, search)
+			assert.Equal(t, cache.ErrEmployeesSearchSet, err)
+			time.Sleep(1500 * time.Millisecond)
+			otherCtx = cache.CtxWithLease(ctx)
+			_, err = c.EmployeesRead(otherCtx, search)
+			assert.Equal(t, cache.ErrEmployeesSearchSet, err)
+			err = c.EmployeesWrite(leaseCtx, search, &data.Employee{EmpNo: 2})
+			assert.ErrorIs(t, err, cache.ErrLeaseInvalid)
+			err = c.EmployeesWrite(otherCtx, search, &data.Employee{EmpNo: 2})
+			assert.Nil(t, err)
+
+			//validate that deleting a sleep revokes its lease
+			sleep := &data.Sleep{Id: internal.GenerateId(), Duration: 1}
+			leaseCtx = cache.CtxWithLease(ctx)
+			_, err = c.SleepRead(leaseCtx, sleep.Id)
+			assert.Equal(t, cache.ErrSleepReadSet, err)
+			err = c.SleepsDelete(ctx, sleep.Id)
+			assert.Nil(t, err)
+			err = c.SleepWrite(leaseCtx, sleep)
+			assert.ErrorIs(t, err, cache.ErrLeaseInvalid)
+			_, err = c.SleepRead(ctx, sleep.Id)
+			assert.Equal(t, cache.ErrSleepReadSet, err)
+		})
+	}
+}
+
+func TestCacheFillWait(t *testing.T) {
+	type waiterCache interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+		cache.Waiter
+	}
+
+	logger := utilities.NewLogger()
+	memoryCache := cache.NewMemory(logger)
+	//KIM: redis waiters are notified of fills by any instance, so the
+	// fill is written by a different instance than the one waiting
+	for cacheType, c := range map[string][2]waiterCache{
+		"memory": {memoryCache, memoryCache},
+		"redis":  {cache.NewRedis(logger), cache.NewRedis(logger)},
+	} {
+		t.Run(cacheType, func(t *testing.T) {
+			ctx := context.TODO()
+			waiter, filler := c[0], c[1]
+			waitEnvs := make(map[string]string)
+			for key, value := range envs {
+				waitEnvs[key] = value
+			}
+			waitEnvs["CACHE_ENABLE_IN_PROGRESS"] = "true"
+			waitEnvs["CACHE_SET_READ_TTL"] = "10"
+			waitEnvs["CACHE_PRUNE_INTERVAL"] = "1"
+			caches := []waiterCache{waiter}
+			if filler != waiter {
+				caches = append(caches, filler)
+			}
+			for _, c := range caches {
+				err := c.Configure(waitEnvs)
+				if !assert.Nil(t, err) {
+					assert.FailNow(t, "unable to configure cache")
+				}
+				err = c.Open(ctx)
+				if !assert.Nil(t, err) {
+					assert.FailNow(t, "unable to open cache")
+				}
+				defer func(c waiterCache) {
+					if err := c.Close(ctx); err != nil {
+						t.Logf("error while closing cache: %s", err)
+					}
+				}(c)
+			}
+			err := waiter.Clear(ctx)
+			assert.Nil(t, err)
+
+			//validate that waiting on an entry that isn't being filled
+			// returns immediately
+			employee := &data.Employee{EmpNo: 1, FirstName: internal.GenerateId()}
+			err = waiter.FillWait(ctx, data.CacheEntryTypeEmployee, fmt.Sprint(employee.EmpNo))
+			assert.Nil(t, err)
+
+			//begin a fill and validate that a waiter is notified as soon
+			// as the fill is written
+			leaseCtx := cache.CtxWithLease(ctx)
+			_, err = filler.EmployeeRead(leaseCtx, employee.EmpNo)
+			assert.Equal(t, cache.ErrEmployeeReadSet, err)
+			_, err = waiter.EmployeeRead(ctx, employee.EmpNo)
+			assert.Equal(t, cache.ErrEmployeeReadAlreadySet, err)
+			chErr := make(chan error, 1)
+			go func() {
+				ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				defer cancel()
+				chErr <- waiter.FillWait(ctx, data.CacheEntryTypeEmployee, fmt.Sprint(employee.EmpNo))
+			}()
+			time.Sleep(100 * time.Millisecond)
+			tWrite := time.Now()
+			err = filler.EmployeesWrite(leaseCtx, data.EmployeeSearch{}, employee)
+			assert.Nil(t, err)
+			select {
+			case err := <-chErr:
+				assert.Nil(t, err)
+				assert.Less(t, time.Since(tWrite), time.Second)
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, "waiter not notified")
+			}
+			employeeRead, err := waiter.EmployeeRead(ctx, employee.EmpNo)
+			assert.Nil(t, err)
+			assert.Equal(t, employee, employeeRead)
+
+			//begin a fill and validate that a waiter is notified if the
+			// sleep is deleted and that the wait is bounded by its context
+			sleep := &data.Sleep{Id: internal.GenerateId(), Duration: 1}
+			_, err = filler.SleepRead(cache.CtxWithLease(ctx), sleep.Id)
+			assert.Equal(t, cache.ErrSleepReadSet, err)
+			ctxWait, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+			defer cancel()
+			err = waiter.FillWait(ctxWait, data.CacheEntryTypeSleep, sleep.Id)
+			assert.ErrorIs(t, err, context.DeadlineExceeded)
+			go func() {
+				ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+				defer cancel()
+				chErr <- waiter.FillWait(ctx, data.CacheEntryTypeSleep, sleep.Id)
+			}()
+			time.Sleep(100 * time.Millisecond)
+			err = filler.SleepsDelete(ctx, sleep.Id)
+			assert.Nil(t, err)
+			select {
+			case err := <-chErr:
+				assert.Nil(t, err)
+			case <-time.After(5 * time.Second):
+				assert.Fail(t, "waiter not notified")
+			}
+		})
+	}
+}
+
+func TestCacheNotFound(t *testing.T) {
+	for _, cacheType := range []string{"memory", "redis", "tiered", "invalidator", "stash-memory",
+		"stash-redis"} {
+		t.Run(cacheType, func(t *testing.T) {
+			ctx := context.TODO()
+			c := newCacheTest(cacheType)
+			notFoundEnvs := make(map[string]string)
+			for key, value := range envs {
+				notFoundEnvs[key] = value
+			}
+			notFoundEnvs["CACHE_ENABLE_IN_PROGRESS"] = "false"
+			notFoundEnvs["CACHE_NOT_FOUND_ENABLED"] = "true"
+			notFoundEnvs["CACHE_NOT_FOUND_TTL"] = "1"
+			notFoundEnvs["CACHE_NOT_FOUND_PRUNE_INTERVAL"] = "1"
+			err := c.cache.Configure(notFoundEnvs)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to configure cache")
+			}
+			err = c.cache.Open(ctx)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to open cache")
+			}
+			defer func() {
+				if err := c.cache.Close(ctx); err != nil {
+					t.Logf("error while closing cache: %s", err)
+				}
+			}()
+			t.Run("NotFound", c.TestNotFound)
+		})
+	}
+}
+
+func TestCacheTiered(t *testing.T) {
+	testCache(t, "tiered")
+}
+
+func TestCacheInvalidator(t *testing.T) {
+	testCache(t, "invalidator")
+
+	//create two caches that share an invalidation channel
+	ctx := context.TODO()
+	caches := []*cacheTest{newCacheTest("invalidator"), newCacheTest("invalidator")}
+	for _, c := range caches {
+		err := c.cache.Configure(envs)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to configure cache")
+		}
+		err = c.cache.Open(ctx)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to open cache")
+		}
+		defer func(c *cacheTest) {
+			if err := c.cache.Close(ctx); err != nil {
+				t.Logf("error while closing cache: %s", err)
+			}
+		}(c)
+	}
+
+	//write the same employee to both caches
+	employee := &data.Employee{
+		EmpNo:     1,
+		FirstName: internal.GenerateId(),
+		LastName:  internal.GenerateId(),
+	}
+	for _, c := range caches {
+		err := c.EmployeesWrite(ctx, data.EmployeeSearch{}, employee)
+		assert.Nil(t, err)
+	}
+
+	//delete the employee from the first cache and validate that
+	// it's eventually evicted from the second
+	err := caches[0].EmployeesDelete(ctx, employee.EmpNo)
+	assert.Nil(t, err)
+	assert.Eventually(t, func() bool {
+		_, err := caches[1].EmployeeRead(ctx, employee.EmpNo)
+		return err != nil
+	}, 5*time.Second, 100*time.Millisecond)
+}
+
+func TestCacheInspector(t *testing.T) {
+	logger := utilities.NewLogger()
+	for cacheType, c := range map[string]interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		cache.Cache
+	}{
+		"memory":      cache.NewMemory(logger),
+		"redis":       cache.NewRedis(logger),
+		"tiered":      cache.NewTiered(logger),
+		"invalidator": cache.NewInvalidator(logger, cache.NewMemory(logger)),
+	} {
+		t.Run(cacheType, func(t *testing.T) {
+			ctx := context.TODO()
+			inspectorEnvs := make(map[string]string)
+			for key, value := range envs {
+				inspectorEnvs[key] = value
+			}
+			inspectorEnvs["CACHE_TTL"] = "60"
+			err := c.Configure(inspectorEnvs)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to configure cache")
+			}
+			err = c.Open(ctx)
+			if !assert.Nil(t, err) {
+				assert.FailNow(t, "unable to open cache")
+			}
+			defer func() {
+				if err := c.Close(ctx); err != nil {
+					t.Logf("error while closing cache: %s", err)
+				}
+			}()
+			inspector, ok := c.(cache.Inspector)
+			if !assert.True(t, ok) {
+				assert.FailNow(t, "cache doesn't implement inspector")
+			}
+			err = c.Clear(ctx)
+			assert.Nil(t, err)
+
+			//write an employee, a search and a sleep
+			employee := &data.Employee{EmpNo: 1, FirstName: internal.GenerateId()}
+			search := data.EmployeeSearch{EmpNos: []int64{employee.EmpNo}}
+			searchKey, err := search.ToKey()
+			assert.Nil(t, err)
+			err = c.EmployeesWrite(ctx, search, employee)
+			assert.Nil(t, err)
+			sleep := &data.Sleep{Id: internal.GenerateId(), Duration: 1}
+			err = c.SleepWrite(ctx, sleep)
+			assert.Nil(t, err)
+
+			//list the keys and validate their age and ttl
+			keys, err := inspector.KeysRead(ctx, "")
+			assert.Nil(t, err)
+			assert.Len(t, keys, 3)
+			for _, key := range keys {
+				assert.GreaterOrEqual(t, key.Age, int64(0))
+				assert.Greater(t, key.TTL, int64(0))
+				assert.LessOrEqual(t, key.TTL, int64(60000))
+				assert.False(t, key.Stale)
+			}
+			keys, err = inspector.KeysRead(ctx, data.CacheEntryTypeEmployee)
+			assert.Nil(t, err)
+			if assert.Len(t, keys, 1) {
+				assert.Equal(t, "1", keys[0].Key)
+			}
+			_, err = inspector.KeysRead(ctx, "not_an_entry_type")
+			assert.ErrorIs(t, err, cache.ErrCacheEntryTypeUnsupported)
+
+			//read the entries as stored
+			entry, err := inspector.EntryRead(ctx, data.CacheEntryTypeEmployee, "1")
+			if assert.Nil(t, err) {
+				employeeRead := &data.Employee{}
+				err = employeeRead.UnmarshalBinary(entry.Value)
+				assert.Nil(t, err)
+				assert.Equal(t, employee, employeeRead)
+			}
+			entry, err = inspector.EntryRead(ctx, data.CacheEntryTypeEmployeeSearch, searchKey)
+			if assert.Nil(t, err) {
+				assert.Equal(t, searchKey, entry.Key)
+				assert.Contains(t, string(entry.Value), `"emp_nos":[1]`)
+			}
+			entry, err = inspector.EntryRead(ctx, data.CacheEntryTypeSleep, sleep.Id)
+			if assert.Nil(t, err) {
+				assert.Equal(t, sleep.Id, entry.Key)
+			}
+			_, err = inspector.EntryRead(ctx, data.CacheEntryTypeEmployee, "2")
+			assert.ErrorIs(t, err, data.ErrNotFound)
+
+			//evict each entry and validate that it's no longer cached
+			err = inspector.EntryDelete(ctx, data.CacheEntryTypeSleep, sleep.Id)
+			assert.Nil(t, err)
+			_, err = c.SleepRead(ctx, sleep.Id)
+			assert.NotNil(t, err)
+			err = inspector.EntryDelete(ctx, data.CacheEntryTypeEmployeeSearch, searchKey)
+			assert.Nil(t, err)
+			_, err = c.EmployeesRead(ctx, search)
+			assert.NotNil(t, err)
+			err = inspector.EntryDelete(ctx, data.CacheEntryTypeEmployee, "1")
+			assert.Nil(t, err)
+			_, err = c.EmployeeRead(ctx, employee.EmpNo)
+			assert.NotNil(t, err)
+			keys, err = inspector.KeysRead(ctx, "")
+			assert.Nil(t, err)
+			assert.Len(t, keys, 0)
+		})
+	}
+}
+
 func TestCacheStash(t *testing.T) {
-	testCache(t, "stash")
+	for _, cacheType := range []string{"stash-memory", "stash-redis"} {
+		t.Run(cacheType, func(t *testing.T) {
+			testCache(t, cacheType)
+		})
+	}
 }
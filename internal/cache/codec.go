@@ -0,0 +1,227 @@
+package cache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// codecFormat identifies the codec used to encode a value, it's stored in
+// the header of each entry so entries can be decoded regardless of the
+// codec that's configured
+type codecFormat byte
+
+const (
+	codecFormatJSON     codecFormat = 1
+	codecFormatGob      codecFormat = 2
+	codecFormatMsgpack  codecFormat = 3
+	codecFormatJSONGzip codecFormat = 4
+)
+
+const (
+	codecJSON     string = "json"
+	codecGob      string = "gob"
+	codecMsgpack  string = "msgpack"
+	codecJSONGzip string = "json_gzip"
+)
+
+// entryHeaderMagic is the first byte of an entry with a header, entries
+// written before headers were introduced are json and start with '{'
+const (
+	entryHeaderMagic   byte = 0xCB
+	entryHeaderVersion byte = 1
+	entryHeaderLength  int  = 3 + 3*8 //magic, version, format + cached_at, ttl, delta
+)
+
+// codec encodes and decodes values
+type codec interface {
+	Marshal(v any) ([]byte, error)
+	Unmarshal(bytes []byte, v any) error
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(bytes []byte, v any) error {
+	return json.Unmarshal(bytes, v)
+}
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v any) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	if err := gob.NewEncoder(&buffer).Encode(v); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(b []byte, v any) error {
+	return gob.NewDecoder(bytes.NewReader(b)).Decode(v)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v any) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+func (msgpackCodec) Unmarshal(bytes []byte, v any) error {
+	return msgpack.Unmarshal(bytes, v)
+}
+
+// gzipCodec compresses the output of another codec
+type gzipCodec struct {
+	codec
+}
+
+func (c gzipCodec) Marshal(v any) ([]byte, error) {
+	var buffer bytes.Buffer
+
+	b, err := c.codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(b); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+func (c gzipCodec) Unmarshal(b []byte, v any) error {
+	reader, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	b, err = io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	return c.codec.Unmarshal(b, v)
+}
+
+var codecs = map[codecFormat]codec{
+	codecFormatJSON:     jsonCodec{},
+	codecFormatGob:      gobCodec{},
+	codecFormatMsgpack:  msgpackCodec{},
+	codecFormatJSONGzip: gzipCodec{jsonCodec{}},
+}
+
+var codecFormats = map[string]codecFormat{
+	codecJSON:     codecFormatJSON,
+	codecGob:      codecFormatGob,
+	codecMsgpack:  codecFormatMsgpack,
+	codecJSONGzip: codecFormatJSONGzip,
+}
+
+func (f codecFormat) String() string {
+	for name, format := range codecFormats {
+		if format == f {
+			return name
+		}
+	}
+	return fmt.Sprintf("unknown(%d)", byte(f))
+}
+
+// parseCodecFormat returns the codec format for the given codec name
+func parseCodecFormat(name string) (codecFormat, error) {
+	format, ok := codecFormats[name]
+	if !ok {
+		return 0, fmt.Errorf("codec (%s) not supported", name)
+	}
+	return format, nil
+}
+
+// legacyEntry is how entries were stored before headers were introduced
+type legacyEntry struct {
+	CachedAt int64           `json:"cached_at"`
+	TTL      time.Duration   `json:"ttl"`
+	Delta    time.Duration   `json:"delta,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
+
+// storedEntry is an entry as stored: a header (magic, version and
+// format), the entry's expiry and the value encoded with the codec
+// identified by the format
+type storedEntry struct {
+	entryExpiry
+	format codecFormat
+	value  []byte
+}
+
+func encodeEntry(format codecFormat, expiry entryExpiry, v any) ([]byte, error) {
+	codec, ok := codecs[format]
+	if !ok {
+		return nil, fmt.Errorf("codec format (%d) not supported", format)
+	}
+	value, err := codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, entryHeaderLength, entryHeaderLength+len(value))
+	b[0], b[1], b[2] = entryHeaderMagic, entryHeaderVersion, byte(format)
+	binary.BigEndian.PutUint64(b[3:], uint64(expiry.cachedAt))
+	binary.BigEndian.PutUint64(b[11:], uint64(expiry.ttl))
+	binary.BigEndian.PutUint64(b[19:], uint64(expiry.delta))
+	return append(b, value...), nil
+}
+
+func decodeEntry(b []byte) (*storedEntry, error) {
+	if len(b) > 0 && b[0] == '{' {
+		entry := &legacyEntry{}
+		if err := json.Unmarshal(b, entry); err != nil {
+			return nil, err
+		}
+		return &storedEntry{
+			entryExpiry: entryExpiry{
+				cachedAt: entry.CachedAt,
+				ttl:      entry.TTL,
+				delta:    entry.Delta,
+			},
+			format: codecFormatJSON,
+			value:  entry.Value,
+		}, nil
+	}
+	if len(b) < entryHeaderLength || b[0] != entryHeaderMagic {
+		return nil, fmt.Errorf("entry header not found")
+	}
+	if b[1] != entryHeaderVersion {
+		return nil, fmt.Errorf("entry header version (%d) not supported, expected %d",
+			b[1], entryHeaderVersion)
+	}
+	return &storedEntry{
+		entryExpiry: entryExpiry{
+			cachedAt: int64(binary.BigEndian.Uint64(b[3:])),
+			ttl:      time.Duration(binary.BigEndian.Uint64(b[11:])),
+			delta:    time.Duration(binary.BigEndian.Uint64(b[19:])),
+		},
+		format: codecFormat(b[2]),
+		value:  b[entryHeaderLength:],
+	}, nil
+}
+
+// decode will decode the value of the entry using the codec it was
+// encoded with
+func (e *storedEntry) decode(v any) error {
+	codec, ok := codecs[e.format]
+	if !ok {
+		return fmt.Errorf("codec format (%d) not supported", e.format)
+	}
+	return codec.Unmarshal(e.value, v)
+}
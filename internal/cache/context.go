@@ -0,0 +1,82 @@
+package cache
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+type ctxKeyFencingToken struct{}
+
+// CtxWithFencingToken attaches a fencing token (from Mutex.Lock) to the
+// context, caches that support fencing will reject writes with a token
+// older than the last token used to write the same entry
+func CtxWithFencingToken(ctx context.Context, fencingToken int64) context.Context {
+	return context.WithValue(ctx, ctxKeyFencingToken{}, fencingToken)
+}
+
+func FencingTokenFromCtx(ctx context.Context) (int64, bool) {
+	item := ctx.Value(ctxKeyFencingToken{})
+	fencingToken, ok := item.(int64)
+	return fencingToken, ok
+}
+
+type ctxKeyFetchDuration struct{}
+
+// CtxWithFetchDuration attaches how long it took to fetch the value(s)
+// being written to the context, this is used to determine the probability
+// of recomputing the cached value(s) before they expire
+func CtxWithFetchDuration(ctx context.Context, fetchDuration time.Duration) context.Context {
+	return context.WithValue(ctx, ctxKeyFetchDuration{}, fetchDuration)
+}
+
+func FetchDurationFromCtx(ctx context.Context) (time.Duration, bool) {
+	item := ctx.Value(ctxKeyFetchDuration{})
+	fetchDuration, ok := item.(time.Duration)
+	return fetchDuration, ok
+}
+
+type ctxKeyGeneration struct{}
+
+// CtxWithGeneration attaches the generation (from Versioner.Generation)
+// read before a fill began to the context, caches that support versioning
+// will reject writes of entries invalidated after that generation
+func CtxWithGeneration(ctx context.Context, generation int64) context.Context {
+	return context.WithValue(ctx, ctxKeyGeneration{}, generation)
+}
+
+func GenerationFromCtx(ctx context.Context) (int64, bool) {
+	item := ctx.Value(ctxKeyGeneration{})
+	generation, ok := item.(int64)
+	return generation, ok
+}
+
+// ctxWithoutGeneration removes the generation from the context, this is
+// used when writing to a cache whose generation the fill didn't read
+func ctxWithoutGeneration(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyGeneration{}, nil)
+}
+
+type ctxKeyLease struct{}
+
+// CtxWithLease returns a context that can hold a lease, caches that hand
+// out leases will attach the lease's token to the context on a miss; the
+// same context should be used for the write that fills the entry
+func CtxWithLease(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyLease{}, &atomic.Value{})
+}
+
+// LeaseFromCtx returns the token of the lease held by the context, if any
+func LeaseFromCtx(ctx context.Context) (string, bool) {
+	if l, ok := ctx.Value(ctxKeyLease{}).(*atomic.Value); ok {
+		token, ok := l.Load().(string)
+		return token, ok
+	}
+	return "", false
+}
+
+func setLeaseCtx(ctx context.Context, token string) {
+	if l, ok := ctx.Value(ctxKeyLease{}).(*atomic.Value); ok {
+		l.Store(token)
+	}
+}
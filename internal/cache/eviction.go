@@ -0,0 +1,150 @@
+package cache
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/antonio-alexander/go-blog-cache/internal/data"
+)
+
+type evictionPolicy string
+
+const (
+	evictionPolicyLeastRecentlyUsed   evictionPolicy = "least_recently_used"
+	evictionPolicyLeastFrequentlyUsed evictionPolicy = "least_frequently_used"
+	evictionPolicyFirstInFirstOut     evictionPolicy = "first_in_first_out"
+)
+
+func parseEvictionPolicy(s string) (evictionPolicy, error) {
+	switch policy := evictionPolicy(s); policy {
+	case evictionPolicyLeastRecentlyUsed, evictionPolicyLeastFrequentlyUsed,
+		evictionPolicyFirstInFirstOut:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("eviction policy (%s) not supported", s)
+	}
+}
+
+const (
+	entryTypeEmployee       string = data.CacheEntryTypeEmployee
+	entryTypeEmployeeSearch string = data.CacheEntryTypeEmployeeSearch
+	entryTypeSleep          string = data.CacheEntryTypeSleep
+)
+
+type evictionEntry struct {
+	entryType  string
+	key        string
+	size       int
+	createdAt  int64
+	lastRead   int64
+	nTimesRead int
+}
+
+type evictionTracker struct {
+	sync.Mutex
+	entries map[string]*evictionEntry //map[entry_type:key]entry
+	size    int
+}
+
+func newEvictionTracker() *evictionTracker {
+	return &evictionTracker{
+		entries: make(map[string]*evictionEntry),
+	}
+}
+
+func (e *evictionTracker) write(entryType, key string, size int) {
+	e.Lock()
+	defer e.Unlock()
+
+	tNow := time.Now().UnixNano()
+	entry, ok := e.entries[entryType+":"+key]
+	if !ok {
+		entry = &evictionEntry{
+			entryType: entryType,
+			key:       key,
+			createdAt: tNow,
+		}
+		e.entries[entryType+":"+key] = entry
+	}
+	e.size += size - entry.size
+	entry.size, entry.lastRead = size, tNow
+}
+
+func (e *evictionTracker) read(entryType, key string) {
+	e.Lock()
+	defer e.Unlock()
+
+	if entry, ok := e.entries[entryType+":"+key]; ok {
+		entry.lastRead = time.Now().UnixNano()
+		entry.nTimesRead++
+	}
+}
+
+func (e *evictionTracker) delete(entryType, key string) {
+	e.Lock()
+	defer e.Unlock()
+
+	if entry, ok := e.entries[entryType+":"+key]; ok {
+		e.size -= entry.size
+		delete(e.entries, entryType+":"+key)
+	}
+}
+
+func (e *evictionTracker) contains(entryType, key string) bool {
+	e.Lock()
+	defer e.Unlock()
+
+	_, ok := e.entries[entryType+":"+key]
+	return ok
+}
+
+func (e *evictionTracker) clear() {
+	e.Lock()
+	defer e.Unlock()
+
+	e.entries = make(map[string]*evictionEntry)
+	e.size = 0
+}
+
+// exceeds returns true if the tracked entries are over either of
+// the limits, a limit of zero (or less) is treated as unlimited
+func (e *evictionTracker) exceeds(maxEntries, maxSize int) bool {
+	e.Lock()
+	defer e.Unlock()
+
+	return (maxEntries > 0 && len(e.entries) > maxEntries) ||
+		(maxSize > 0 && e.size > maxSize)
+}
+
+// candidates returns a snapshot of all tracked entries ordered such
+// that the first entry is the first that should be evicted according
+// to the provided policy
+func (e *evictionTracker) candidates(policy evictionPolicy) []evictionEntry {
+	e.Lock()
+	defer e.Unlock()
+
+	entries := make([]evictionEntry, 0, len(e.entries))
+	for _, entry := range e.entries {
+		entries = append(entries, *entry)
+	}
+	switch policy {
+	default: //least recently used
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].lastRead < entries[j].lastRead
+		})
+	case evictionPolicyLeastFrequentlyUsed:
+		sort.Slice(entries, func(i, j int) bool {
+			if entries[i].nTimesRead == entries[j].nTimesRead {
+				return entries[i].lastRead < entries[j].lastRead
+			}
+			return entries[i].nTimesRead < entries[j].nTimesRead
+		})
+	case evictionPolicyFirstInFirstOut:
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].createdAt < entries[j].createdAt
+		})
+	}
+	return entries
+}
@@ -0,0 +1,84 @@
+package cache
+
+import (
+	"context"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// entryExpiry tracks when an entry was cached, its (soft) ttl and how
+// long it took to fetch the value that was cached
+type entryExpiry struct {
+	cachedAt int64
+	ttl      time.Duration
+	delta    time.Duration
+}
+
+// newEntryExpiry creates the expiry for an entry being cached now, the
+// ttl is extended by a random amount (up to jitter * ttl) so entries
+// written together don't all expire together
+func newEntryExpiry(ctx context.Context, ttl time.Duration, jitter float64) entryExpiry {
+	if jitter > 0 && ttl > 0 {
+		ttl += time.Duration(rand.Float64() * jitter * float64(ttl))
+	}
+	delta, _ := FetchDurationFromCtx(ctx)
+	return entryExpiry{
+		cachedAt: time.Now().UnixNano(),
+		ttl:      ttl,
+		delta:    delta,
+	}
+}
+
+// expiration returns how long the entry should be kept, if the entry can
+// be served stale, it's kept until the stale ttl passes its soft ttl
+func (e entryExpiry) expiration(staleTTL time.Duration) time.Duration {
+	return e.ttl + staleTTL
+}
+
+func (e entryExpiry) expired(staleTTL time.Duration) bool {
+	return time.Since(time.Unix(0, e.cachedAt)) > e.expiration(staleTTL)
+}
+
+// freshness is whether an entry is fresh, should be refreshed early or
+// is stale; a greater freshness is less fresh
+type freshness int
+
+const (
+	freshnessFresh freshness = iota
+	freshnessRefresh
+	freshnessStale
+)
+
+// err returns the error for the freshness, an entry that's fresh
+// doesn't have an error
+func (f freshness) err(errStale, errRefresh error) error {
+	switch f {
+	default:
+		return nil
+	case freshnessRefresh:
+		return errRefresh
+	case freshnessStale:
+		return errStale
+	}
+}
+
+// freshness returns stale if the entry is past its soft ttl or refresh if
+// it's within its soft ttl but should be recomputed early; the probability
+// of early recomputation (XFetch) grows as the entry approaches its soft
+// ttl and with the time it took to fetch
+// REFERENCE: https://cseweb.ucsd.edu/~avattani/papers/cache_stampede.pdf
+func (e entryExpiry) freshness(staleTTL time.Duration, beta float64) freshness {
+	age := time.Since(time.Unix(0, e.cachedAt))
+	if staleTTL > 0 && age > e.ttl {
+		return freshnessStale
+	}
+	if beta <= 0 || e.delta <= 0 {
+		return freshnessFresh
+	}
+	early := time.Duration(float64(e.delta) * beta * -math.Log(1-rand.Float64()))
+	if age+early >= e.ttl {
+		return freshnessRefresh
+	}
+	return freshnessFresh
+}
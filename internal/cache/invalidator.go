@@ -0,0 +1,432 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/antonio-alexander/go-blog-cache/internal"
+	"github.com/antonio-alexander/go-blog-cache/internal/data"
+	"github.com/antonio-alexander/go-blog-cache/internal/utilities"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	invalidationEmployeesDelete string = "employees_delete"
+	invalidationSearchesDelete  string = "employee_searches_delete"
+	invalidationSleepsDelete    string = "sleeps_delete"
+	invalidationClear           string = "clear"
+)
+
+type invalidation struct {
+	Origin     string   `json:"origin"`
+	Operation  string   `json:"operation"`
+	EmpNos     []int64  `json:"emp_nos,omitempty"`
+	SearchKeys []string `json:"search_keys,omitempty"`
+	SleepIds   []string `json:"sleep_ids,omitempty"`
+}
+
+func (i *invalidation) MarshalBinary() ([]byte, error) {
+	return json.Marshal(i)
+}
+
+func (i *invalidation) UnmarshalBinary(bytes []byte) error {
+	return json.Unmarshal(bytes, i)
+}
+
+type invalidator struct {
+	sync.WaitGroup
+	redisClient *redis.Client
+	cache       interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		Cache
+	}
+	origin string
+	config struct {
+		address           string
+		port              string
+		password          string
+		database          int
+		timeout           time.Duration
+		channel           string
+		reconnectInterval time.Duration
+		redisAuth
+	}
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+	utilities.Logger
+}
+
+// NewInvalidator wraps a cache such that any deletes (or clears) are
+// published over a redis channel and any deletes published by other
+// instances are applied to the wrapped cache; this allows memory caches
+// on multiple instances to evict the same keys
+func NewInvalidator(parameters ...any) interface {
+	internal.Configurer
+	internal.Opener
+	internal.Clearer
+	Cache
+} {
+	c := &invalidator{origin: internal.GenerateId()}
+	for _, parameter := range parameters {
+		switch p := parameter.(type) {
+		case interface {
+			internal.Configurer
+			internal.Opener
+			internal.Clearer
+			Cache
+		}:
+			c.cache = p
+		case utilities.Logger:
+			c.Logger = p
+		}
+	}
+	return c
+}
+
+func (c *invalidator) launchSubscriber(pubSub *redis.PubSub) {
+	started := make(chan struct{})
+	c.Add(1)
+	go func() {
+		defer c.Done()
+
+		close(started)
+		for {
+			if err := c.receive(pubSub); err != nil {
+				c.Error(c.ctx, "error while receiving from invalidation channel (%s): %s",
+					c.config.channel, err)
+			}
+			for pubSub = nil; pubSub == nil; {
+				select {
+				case <-c.ctx.Done():
+					return
+				case <-time.After(c.config.reconnectInterval):
+				}
+				c.Info(c.ctx, "cache: reconnecting to invalidation channel (%s)", c.config.channel)
+				p, err := c.subscribe(c.ctx)
+				if err != nil {
+					c.Error(c.ctx, "error while subscribing to invalidation channel (%s): %s",
+						c.config.channel, err)
+					continue
+				}
+				//KIM: any invalidations published while we were disconnected
+				// were missed, so the only safe thing to do is to clear the cache
+				if err := c.cache.Clear(c.ctx); err != nil {
+					c.Error(c.ctx, "error while clearing cache after reconnect: %s", err)
+				}
+				pubSub = p
+			}
+		}
+	}()
+	<-started
+}
+
+func (c *invalidator) subscribe(ctx context.Context) (*redis.PubSub, error) {
+	pubSub := c.redisClient.Subscribe(ctx, c.config.channel)
+	if _, err := pubSub.Receive(ctx); err != nil {
+		_ = pubSub.Close()
+		return nil, err
+	}
+	c.Info(ctx, "cache: subscribed to invalidation channel (%s)", c.config.channel)
+	return pubSub, nil
+}
+
+// receive will block until the context is cancelled or the subscription
+// fails, in either case the subscription will be closed
+func (c *invalidator) receive(pubSub *redis.PubSub) error {
+	defer pubSub.Close()
+
+	//KIM: receiving a message doesn't return when the context is
+	// cancelled, so the subscription is closed to unblock it
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-c.ctx.Done():
+			_ = pubSub.Close()
+		case <-stopped:
+		}
+	}()
+	for {
+		message, err := pubSub.ReceiveMessage(c.ctx)
+		if err != nil {
+			select {
+			default:
+				return err
+			case <-c.ctx.Done():
+				return nil
+			}
+		}
+		c.handle(message.Payload)
+	}
+}
+
+func (c *invalidator) handle(payload string) {
+	var i invalidation
+
+	if err := i.UnmarshalBinary([]byte(payload)); err != nil {
+		c.Error(c.ctx, "error while unmarshalling invalidation: %s", err)
+		return
+	}
+	if i.Origin == c.origin {
+		return
+	}
+	switch i.Operation {
+	default:
+		c.Error(c.ctx, "unsupported invalidation operation: %s", i.Operation)
+	case invalidationEmployeesDelete:
+		if err := c.cache.EmployeesDelete(c.ctx, i.EmpNos...); err != nil {
+			c.Error(c.ctx, "error while invalidating employees (%v): %s", i.EmpNos, err)
+			return
+		}
+		c.Trace(c.ctx, "invalidated employees: %v", i.EmpNos)
+	case invalidationSearchesDelete:
+		if err := c.cache.EmployeeSearchesDelete(c.ctx, i.SearchKeys...); err != nil {
+			c.Error(c.ctx, "error while invalidating employee searches (%v): %s", i.SearchKeys, err)
+			return
+		}
+		c.Trace(c.ctx, "invalidated employee searches: %v", i.SearchKeys)
+	case invalidationSleepsDelete:
+		if err := c.cache.SleepsDelete(c.ctx, i.SleepIds...); err != nil {
+			c.Error(c.ctx, "error while invalidating sleeps (%v): %s", i.SleepIds, err)
+			return
+		}
+		c.Trace(c.ctx, "invalidated sleeps: %v", i.SleepIds)
+	case invalidationClear:
+		if err := c.cache.Clear(c.ctx); err != nil {
+			c.Error(c.ctx, "error while invalidating (clear): %s", err)
+			return
+		}
+		c.Trace(c.ctx, "invalidated (clear)")
+	}
+}
+
+func (c *invalidator) publish(ctx context.Context, i *invalidation) error {
+	i.Origin = c.origin
+	bytes, err := i.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
+	defer cancel()
+	if err := c.redisClient.Publish(ctx, c.config.channel, bytes).Err(); err != nil {
+		return data.NewError(err)
+	}
+	return nil
+}
+
+func (c *invalidator) Configure(envs map[string]string) error {
+	if err := c.cache.Configure(envs); err != nil {
+		return err
+	}
+	if redisAddress, ok := envs["REDIS_ADDRESS"]; ok {
+		c.config.address = redisAddress
+	}
+	if redisPort, ok := envs["REDIS_PORT"]; ok {
+		c.config.port = redisPort
+	}
+	if redisPassword, ok := envs["REDIS_PASSWORD"]; ok {
+		c.config.password = redisPassword
+	}
+	c.config.redisAuth.configure(envs)
+	if redisDatabase, ok := envs["REDIS_DATABASE"]; ok {
+		i, _ := strconv.ParseInt(redisDatabase, 10, 64)
+		c.config.database = int(i)
+	}
+	c.config.timeout = 10 * time.Second
+	if redisTimeout, ok := envs["REDIS_TIMEOUT"]; ok {
+		i, _ := strconv.ParseInt(redisTimeout, 10, 64)
+		c.config.timeout = time.Duration(i) * time.Second
+	}
+	c.config.channel = "cache_invalidation"
+	if channel, ok := envs["CACHE_INVALIDATION_CHANNEL"]; ok && channel != "" {
+		c.config.channel = channel
+	}
+	//KIM: channels aren't scoped to a database, so the channel is
+	// namespaced like the keys of the cache to keep deployments that
+	// share a redis from invalidating each other's caches
+	if s, ok := envs["CACHE_KEY_PREFIX"]; ok && s != "" {
+		keyPrefix, err := parseKeyPrefix(s)
+		if err != nil {
+			return err
+		}
+		c.config.channel = keyPrefix + c.config.channel
+	}
+	c.config.reconnectInterval = time.Second
+	if s, ok := envs["CACHE_INVALIDATION_RECONNECT_INTERVAL"]; ok {
+		i, _ := strconv.ParseInt(s, 10, 64)
+		c.config.reconnectInterval = time.Duration(i) * time.Second
+	}
+	return nil
+}
+
+func (c *invalidator) Open(ctx context.Context) (err error) {
+	if err := c.cache.Open(ctx); err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if err := c.cache.Close(ctx); err != nil {
+				c.Error(ctx, "error while closing cache: %s", err)
+			}
+		}
+	}()
+	tlsConfig, err := c.config.tlsConfig()
+	if err != nil {
+		return err
+	}
+	redisClient := redis.NewClient(&redis.Options{
+		Addr:      net.JoinHostPort(c.config.address, c.config.port),
+		Username:  c.config.username,
+		Password:  c.config.password,
+		DB:        c.config.database,
+		TLSConfig: tlsConfig,
+	})
+	defer func() {
+		if err != nil {
+			if err := redisClient.Close(); err != nil {
+				c.Error(ctx, "error while shutting down redis client: %s", err)
+			}
+		}
+	}()
+	if err := redisClient.Ping(ctx).Err(); err != nil {
+		return err
+	}
+	c.redisClient = redisClient
+	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
+	pubSub, err := c.subscribe(ctx)
+	if err != nil {
+		c.ctxCancel()
+		return err
+	}
+	c.launchSubscriber(pubSub)
+	c.Info(ctx, "cache: invalidation enabled (%s)", c.config.channel)
+	return nil
+}
+
+func (c *invalidator) Close(ctx context.Context) error {
+	c.ctxCancel()
+	c.Wait()
+	if err := c.redisClient.Close(); err != nil {
+		c.Error(ctx, "error while shutting down redis client: %s", err)
+	}
+	return c.cache.Close(ctx)
+}
+
+func (c *invalidator) Clear(ctx context.Context) error {
+	if err := c.cache.Clear(ctx); err != nil {
+		return err
+	}
+	return c.publish(ctx, &invalidation{
+		Operation: invalidationClear,
+	})
+}
+
+func (c *invalidator) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee, error) {
+	return c.cache.EmployeeRead(ctx, empNo)
+}
+
+func (c *invalidator) EmployeesRead(ctx context.Context, search data.EmployeeSearch) ([]*data.Employee, error) {
+	return c.cache.EmployeesRead(ctx, search)
+}
+
+func (c *invalidator) EmployeesWrite(ctx context.Context, search data.EmployeeSearch, employees ...*data.Employee) error {
+	return c.cache.EmployeesWrite(ctx, search, employees...)
+}
+
+func (c *invalidator) EmployeesDelete(ctx context.Context, empNos ...int64) error {
+	if err := c.cache.EmployeesDelete(ctx, empNos...); err != nil {
+		return err
+	}
+	if len(empNos) <= 0 {
+		return nil
+	}
+	return c.publish(ctx, &invalidation{
+		Operation: invalidationEmployeesDelete,
+		EmpNos:    empNos,
+	})
+}
+
+func (c *invalidator) EmployeesNotFoundWrite(ctx context.Context, search data.EmployeeSearch, empNos ...int64) error {
+	return c.cache.EmployeesNotFoundWrite(ctx, search, empNos...)
+}
+
+func (c *invalidator) EmployeeSearchesRead(ctx context.Context) (map[string]data.EmployeeSearch, error) {
+	return c.cache.EmployeeSearchesRead(ctx)
+}
+
+// EmployeeSearchesDelete will delete the searches and publish their keys,
+// other instances only evict searches with the same keys (i.e. criteria)
+func (c *invalidator) EmployeeSearchesDelete(ctx context.Context, searchKeys ...string) error {
+	if err := c.cache.EmployeeSearchesDelete(ctx, searchKeys...); err != nil {
+		return err
+	}
+	if len(searchKeys) <= 0 {
+		return nil
+	}
+	return c.publish(ctx, &invalidation{
+		Operation:  invalidationSearchesDelete,
+		SearchKeys: searchKeys,
+	})
+}
+
+func (c *invalidator) SleepRead(ctx context.Context, sleepId string) (*data.Sleep, error) {
+	return c.cache.SleepRead(ctx, sleepId)
+}
+
+func (c *invalidator) SleepWrite(ctx context.Context, sleep *data.Sleep) error {
+	return c.cache.SleepWrite(ctx, sleep)
+}
+
+func (c *invalidator) SleepsDelete(ctx context.Context, sleepIds ...string) error {
+	if err := c.cache.SleepsDelete(ctx, sleepIds...); err != nil {
+		return err
+	}
+	if len(sleepIds) <= 0 {
+		return nil
+	}
+	return c.publish(ctx, &invalidation{
+		Operation: invalidationSleepsDelete,
+		SleepIds:  sleepIds,
+	})
+}
+
+func (c *invalidator) Generation(ctx context.Context) (int64, error) {
+	versioner, ok := c.cache.(Versioner)
+	if !ok {
+		return 0, ErrVersioningUnsupported
+	}
+	return versioner.Generation(ctx)
+}
+
+func (c *invalidator) FillWait(ctx context.Context, entryType, key string) error {
+	return FillWait(ctx, c.cache, entryType, key)
+}
+
+func (c *invalidator) KeysRead(ctx context.Context, entryType string) ([]data.CacheKey, error) {
+	inspector, ok := c.cache.(Inspector)
+	if !ok {
+		return nil, ErrInspectionUnsupported
+	}
+	return inspector.KeysRead(ctx, entryType)
+}
+
+func (c *invalidator) EntryRead(ctx context.Context, entryType, key string) (*data.CacheEntry, error) {
+	inspector, ok := c.cache.(Inspector)
+	if !ok {
+		return nil, ErrInspectionUnsupported
+	}
+	return inspector.EntryRead(ctx, entryType, key)
+}
+
+func (c *invalidator) EntryDelete(ctx context.Context, entryType, key string) error {
+	//KIM: entries are deleted through the invalidator so the
+	// delete is published to the other instances
+	return entryDelete(ctx, c, entryType, key)
+}
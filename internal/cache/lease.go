@@ -0,0 +1,69 @@
+package cache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/antonio-alexander/go-blog-cache/internal"
+)
+
+// lease is handed out on a miss to the caller that should fill the entry,
+// only a write with the lease's token will be accepted while the lease is
+// held; a lease that isn't used within the in progress ttl expires so a
+// fill whose owner has died can be handed to another caller
+type lease struct {
+	token     string
+	grantedAt int64
+}
+
+func newLease() lease {
+	return lease{
+		token:     internal.GenerateId(),
+		grantedAt: time.Now().UnixNano(),
+	}
+}
+
+func (l lease) expired(ttl time.Duration) bool {
+	return time.Since(time.Unix(0, l.grantedAt)) > ttl
+}
+
+// String returns the lease as stored, <granted_at>:<token>
+func (l lease) String() string {
+	return fmt.Sprintf("%d:%s", l.grantedAt, l.token)
+}
+
+// parseLease parses a lease as stored, markers written before leases were
+// introduced only contain the time they were set and have no token
+func parseLease(s string) lease {
+	grantedAt, token, _ := strings.Cut(s, ":")
+	t, _ := strconv.ParseInt(grantedAt, 10, 64)
+	return lease{
+		token:     token,
+		grantedAt: t,
+	}
+}
+
+// grantLease will grant a lease for the key if a lease isn't held for
+// the key or the lease held has expired, false is returned if a lease
+// is already held
+func grantLease[K comparable](leases map[K]lease, key K, ttl time.Duration) (lease, bool) {
+	if l, ok := leases[key]; ok && !l.expired(ttl) {
+		return l, false
+	}
+	l := newLease()
+	leases[key] = l
+	return l, true
+}
+
+// leaseHeld returns true if the token holds an unexpired lease for any
+// of the keys
+func leaseHeld[K comparable](leases map[K]lease, token string, ttl time.Duration, keys ...K) bool {
+	for _, key := range keys {
+		if l, ok := leases[key]; ok && l.token == token && !l.expired(ttl) {
+			return true
+		}
+	}
+	return false
+}
@@ -2,6 +2,9 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -13,43 +16,72 @@ import (
 
 type cacheEmployee struct {
 	*data.Employee
-	cachedAt int64
+	entryExpiry
 }
 
 type cachedSleep struct {
 	*data.Sleep
-	cachedAt int64
+	entryExpiry
 }
 
 type cachedEmployeeSearch struct {
-	empNos   map[int64]struct{}
+	search data.EmployeeSearch
+	empNos map[int64]struct{}
+	entryExpiry
+}
+
+type notFoundEmployeeSearch struct {
+	search   data.EmployeeSearch
 	cachedAt int64
 }
 
+// memoryInvalidation is the generation an entry was invalidated at
+type memoryInvalidation struct {
+	generation    int64
+	invalidatedAt int64
+}
+
 type memoryCache struct {
 	sync.RWMutex
 	sync.WaitGroup
 	employees        map[int64]cacheEmployee         //map[emp_no]cached_employee
 	employeeSearches map[string]cachedEmployeeSearch //map[search]cached_employee_search
+	searchIndex      map[int64]map[string]struct{}   //map[emp_no]map[search]
 	sleeps           map[string]cachedSleep          //map[sleep_id]cached_sleep
 	inProgress       struct {
 		sync.RWMutex
-		employeeRead   map[int64]int64  //map[emp_no]epoch
-		employeeSearch map[string]int64 //map[search]epoch
-		sleepRead      map[string]int64 //map[sleep_id]epoch
+		employeeRead   map[int64]lease  //map[emp_no]lease
+		employeeSearch map[string]lease //map[search]lease
+		sleepRead      map[string]lease //map[sleep_id]lease
 	}
 	notFound struct {
 		sync.RWMutex
-		employeeNotFound       map[int64]int64  //map[emp_no]epoch
-		employeeSearchNotFound map[string]int64 //map[search]epoch
+		employeeNotFound       map[int64]int64                   //map[emp_no]epoch
+		employeeSearchNotFound map[string]notFoundEmployeeSearch //map[search]not_found_employee_search
 	}
-	config struct {
+	generations struct {
+		generation    int64
+		cleared       int64                         //generation the cache was last cleared at
+		invalidations map[string]memoryInvalidation //map[entry_type:key]invalidation
+	}
+	eviction *evictionTracker
+	waiters  *fillWaiters
+	config   struct {
 		inProgressTTL     time.Duration
 		inProgressEnabled bool
 		notFoundTTL       time.Duration
 		notFoundEnabled   bool
 		pruneInterval     time.Duration
 		cacheTTL          time.Duration
+		hardTTL           time.Duration
+		ttlJitter         float64
+		xFetchBeta        float64
+		maxEntries        int
+		maxSize           int
+		evictionPolicy    evictionPolicy
+		snapshotFile      string
+		snapshotInterval  time.Duration
+		fillTimeout       time.Duration
 	}
 	ctx       context.Context
 	ctxCancel context.CancelFunc
@@ -61,6 +93,9 @@ func NewMemory(parameters ...any) interface {
 	internal.Opener
 	internal.Clearer
 	Cache
+	Inspector
+	Versioner
+	Waiter
 } {
 	c := &memoryCache{}
 	for _, parameter := range parameters {
@@ -82,9 +117,10 @@ func (c *memoryCache) launchPruneSetRead() {
 			c.inProgress.Lock()
 			defer c.inProgress.Unlock()
 
-			for key, t := range c.inProgress.employeeRead {
-				if time.Since(time.Unix(0, t)) > c.config.inProgressTTL {
+			for key, l := range c.inProgress.employeeRead {
+				if l.expired(c.config.inProgressTTL) {
 					delete(c.inProgress.employeeRead, key)
+					c.waiters.notify(entryTypeEmployee, fmt.Sprint(key))
 					c.Trace(c.ctx, "pruned in progress (employee): %d", key)
 				}
 			}
@@ -93,9 +129,10 @@ func (c *memoryCache) launchPruneSetRead() {
 			c.inProgress.Lock()
 			defer c.inProgress.Unlock()
 
-			for key, t := range c.inProgress.employeeSearch {
-				if time.Since(time.Unix(0, t)) > c.config.inProgressTTL {
+			for key, l := range c.inProgress.employeeSearch {
+				if l.expired(c.config.inProgressTTL) {
 					delete(c.inProgress.employeeSearch, key)
+					c.waiters.notify(entryTypeEmployeeSearch, key)
 					c.Trace(c.ctx, "pruned in progress (employee_search): %s", key)
 				}
 			}
@@ -104,9 +141,10 @@ func (c *memoryCache) launchPruneSetRead() {
 			c.inProgress.Lock()
 			defer c.inProgress.Unlock()
 
-			for key, t := range c.inProgress.sleepRead {
-				if time.Since(time.Unix(0, t)) > c.config.inProgressTTL {
+			for key, l := range c.inProgress.sleepRead {
+				if l.expired(c.config.inProgressTTL) {
 					delete(c.inProgress.sleepRead, key)
+					c.waiters.notify(entryTypeSleep, key)
 					c.Trace(c.ctx, "pruned in progress (sleep): %s", key)
 				}
 			}
@@ -150,7 +188,7 @@ func (c *memoryCache) launchPruneNotFound() {
 			defer c.notFound.Unlock()
 
 			for key, t := range c.notFound.employeeSearchNotFound {
-				if time.Since(time.Unix(0, t)) > c.config.notFoundTTL {
+				if time.Since(time.Unix(0, t.cachedAt)) > c.config.notFoundTTL {
 					delete(c.notFound.employeeSearchNotFound, key)
 					c.Trace(c.ctx, "pruned not found (employee_search): %s", key)
 				}
@@ -183,8 +221,8 @@ func (c *memoryCache) launchPruneCache() {
 			defer c.Unlock()
 
 			for key, t := range c.employees {
-				if time.Since(time.Unix(0, t.cachedAt)) > c.config.cacheTTL {
-					delete(c.employees, key)
+				if t.expired(c.staleTTL()) {
+					c.deleteEmployee(key)
 					c.Trace(c.ctx, "pruned (employee): %d", key)
 				}
 			}
@@ -194,13 +232,9 @@ func (c *memoryCache) launchPruneCache() {
 			defer c.Unlock()
 
 			for key, t := range c.employeeSearches {
-				if time.Since(time.Unix(0, t.cachedAt)) > c.config.cacheTTL {
-					delete(c.employeeSearches, key)
+				if t.expired(c.staleTTL()) {
+					c.deleteEmployeeSearch(key)
 					c.Trace(c.ctx, "pruned (employee_search): %s", key)
-					for empNo := range t.empNos {
-						delete(c.employees, empNo)
-						c.Trace(c.ctx, "pruned (employee): %d", key)
-					}
 				}
 			}
 		}
@@ -209,12 +243,22 @@ func (c *memoryCache) launchPruneCache() {
 			defer c.Unlock()
 
 			for key, t := range c.sleeps {
-				if time.Since(time.Unix(0, t.cachedAt)) > c.config.cacheTTL {
-					delete(c.sleeps, key)
+				if t.expired(c.staleTTL()) {
+					c.deleteSleep(key)
 					c.Trace(c.ctx, "pruned (sleep): %s", key)
 				}
 			}
 		}
+		pruneInvalidationsFx := func() {
+			c.Lock()
+			defer c.Unlock()
+
+			for key, invalidation := range c.generations.invalidations {
+				if time.Since(time.Unix(0, invalidation.invalidatedAt)) > c.config.fillTimeout {
+					delete(c.generations.invalidations, key)
+				}
+			}
+		}
 		tPrune := time.NewTicker(c.config.pruneInterval)
 		defer tPrune.Stop()
 		close(started)
@@ -226,12 +270,272 @@ func (c *memoryCache) launchPruneCache() {
 				pruneSleepReadFx()
 				pruneEmployeeSearchFx() //searched before employees because of overlap
 				pruneEmployeeReadFx()
+				pruneInvalidationsFx()
+			}
+		}
+	}()
+	<-started
+}
+
+func (c *memoryCache) launchSnapshot() {
+	started := make(chan struct{})
+	c.Add(1)
+	go func() {
+		defer c.Done()
+
+		snapshotFx := func() {
+			c.RLock()
+			defer c.RUnlock()
+
+			if err := writeSnapshot(c.config.snapshotFile, c.snapshot()); err != nil {
+				c.Error(c.ctx, "error while writing snapshot (%s): %s", c.config.snapshotFile, err)
+				return
+			}
+			c.Trace(c.ctx, "wrote snapshot (%s)", c.config.snapshotFile)
+		}
+		tSnapshot := time.NewTicker(c.config.snapshotInterval)
+		defer tSnapshot.Stop()
+		close(started)
+		for {
+			select {
+			case <-c.ctx.Done():
+				return
+			case <-tSnapshot.C:
+				snapshotFx()
 			}
 		}
 	}()
 	<-started
 }
 
+// snapshot will create a snapshot of the cache, it assumes that the
+// cache has already been locked
+func (c *memoryCache) snapshot() *memorySnapshot {
+	snapshot := &memorySnapshot{
+		Version:          snapshotVersion,
+		CreatedAt:        time.Now().UnixNano(),
+		Employees:        make([]snapshotEmployee, 0, len(c.employees)),
+		EmployeeSearches: make([]snapshotEmployeeSearch, 0, len(c.employeeSearches)),
+		Sleeps:           make([]snapshotSleep, 0, len(c.sleeps)),
+	}
+	for _, employee := range c.employees {
+		snapshot.Employees = append(snapshot.Employees, snapshotEmployee{
+			Employee:       employee.Employee,
+			snapshotExpiry: newSnapshotExpiry(employee.entryExpiry),
+		})
+	}
+	for searchKey, employeeSearch := range c.employeeSearches {
+		empNos := make([]int64, 0, len(employeeSearch.empNos))
+		for empNo := range employeeSearch.empNos {
+			empNos = append(empNos, empNo)
+		}
+		snapshot.EmployeeSearches = append(snapshot.EmployeeSearches, snapshotEmployeeSearch{
+			SearchKey:      searchKey,
+			Search:         employeeSearch.search,
+			EmpNos:         empNos,
+			snapshotExpiry: newSnapshotExpiry(employeeSearch.entryExpiry),
+		})
+	}
+	for _, sleep := range c.sleeps {
+		snapshot.Sleeps = append(snapshot.Sleeps, snapshotSleep{
+			Sleep:          sleep.Sleep,
+			snapshotExpiry: newSnapshotExpiry(sleep.entryExpiry),
+		})
+	}
+	return snapshot
+}
+
+// restore will populate the cache from a snapshot, any entries that have
+// already expired are dropped; it assumes that the cache has already been
+// locked
+func (c *memoryCache) restore(snapshot *memorySnapshot) (nRestored int) {
+	for _, e := range snapshot.Employees {
+		expiry := e.expiry()
+		if e.Employee == nil || expiry.expired(c.staleTTL()) {
+			continue
+		}
+		c.employees[e.Employee.EmpNo] = cacheEmployee{
+			Employee:    e.Employee,
+			entryExpiry: expiry,
+		}
+		if c.eviction != nil {
+			c.eviction.write(entryTypeEmployee, fmt.Sprint(e.Employee.EmpNo),
+				employeeSize(e.Employee))
+		}
+		nRestored++
+	}
+	for _, s := range snapshot.EmployeeSearches {
+		expiry := s.expiry()
+		if expiry.expired(c.staleTTL()) {
+			continue
+		}
+		empNos := make(map[int64]struct{})
+		for _, empNo := range s.EmpNos {
+			empNos[empNo] = struct{}{}
+		}
+		c.employeeSearches[s.SearchKey] = cachedEmployeeSearch{
+			search:      s.Search,
+			empNos:      empNos,
+			entryExpiry: expiry,
+		}
+		for empNo := range empNos {
+			if _, ok := c.searchIndex[empNo]; !ok {
+				c.searchIndex[empNo] = make(map[string]struct{})
+			}
+			c.searchIndex[empNo][s.SearchKey] = struct{}{}
+		}
+		if c.eviction != nil {
+			c.eviction.write(entryTypeEmployeeSearch, s.SearchKey,
+				len(s.SearchKey)+8*len(empNos))
+		}
+		nRestored++
+	}
+	for _, s := range snapshot.Sleeps {
+		expiry := s.expiry()
+		if s.Sleep == nil || expiry.expired(c.staleTTL()) {
+			continue
+		}
+		c.sleeps[s.Sleep.Id] = cachedSleep{
+			Sleep:       s.Sleep,
+			entryExpiry: expiry,
+		}
+		if c.eviction != nil {
+			c.eviction.write(entryTypeSleep, s.Sleep.Id, sleepSize(s.Sleep))
+		}
+		nRestored++
+	}
+	c.evict()
+	return
+}
+
+// staleTTL returns how long an entry can be served stale once it's
+// past its soft ttl, entries can only be served stale if a hard ttl
+// is configured
+func (c *memoryCache) staleTTL() time.Duration {
+	if c.config.hardTTL > c.config.cacheTTL {
+		return c.config.hardTTL - c.config.cacheTTL
+	}
+	return 0
+}
+
+func (c *memoryCache) newEntryExpiry(ctx context.Context) entryExpiry {
+	return newEntryExpiry(ctx, c.config.cacheTTL, c.config.ttlJitter)
+}
+
+// invalidate will increment the generation and record it as the generation
+// the given entries were invalidated at, it assumes that the cache has
+// already been locked
+func (c *memoryCache) invalidate(entryType string, keys ...string) {
+	c.generations.generation++
+	tNow := time.Now().UnixNano()
+	for _, key := range keys {
+		c.generations.invalidations[entryType+":"+key] = memoryInvalidation{
+			generation:    c.generations.generation,
+			invalidatedAt: tNow,
+		}
+	}
+}
+
+// invalidated returns true if the generation attached to the context is
+// older than the generation any of the given entries were invalidated at,
+// it assumes that the cache has already been locked
+func (c *memoryCache) invalidated(ctx context.Context, entryType string, keys ...string) bool {
+	generation, ok := GenerationFromCtx(ctx)
+	if !ok {
+		return false
+	}
+	if c.generations.cleared > generation {
+		return true
+	}
+	for _, key := range keys {
+		if invalidation, ok := c.generations.invalidations[entryType+":"+key]; ok &&
+			invalidation.generation > generation {
+			return true
+		}
+	}
+	return false
+}
+
+// deleteEmployee will remove an employee from the cache, it assumes
+// that the cache has already been locked
+func (c *memoryCache) deleteEmployee(empNo int64) {
+	delete(c.employees, empNo)
+	if c.eviction != nil {
+		c.eviction.delete(entryTypeEmployee, fmt.Sprint(empNo))
+	}
+}
+
+// deleteEmployeeSearch will remove an employee search from the cache
+// (and the search index), it assumes that the cache has already been
+// locked
+func (c *memoryCache) deleteEmployeeSearch(searchKey string) {
+	if employeeSearch, ok := c.employeeSearches[searchKey]; ok {
+		for empNo := range employeeSearch.empNos {
+			delete(c.searchIndex[empNo], searchKey)
+			if len(c.searchIndex[empNo]) == 0 {
+				delete(c.searchIndex, empNo)
+			}
+		}
+	}
+	delete(c.employeeSearches, searchKey)
+	if c.eviction != nil {
+		c.eviction.delete(entryTypeEmployeeSearch, searchKey)
+	}
+}
+
+// deleteEmployeeSearches will remove any employee search that references
+// the given employee using the search index, it assumes that the cache
+// has already been locked
+func (c *memoryCache) deleteEmployeeSearches(empNo int64) {
+	for searchKey := range c.searchIndex[empNo] {
+		c.deleteEmployeeSearch(searchKey)
+	}
+	delete(c.searchIndex, empNo)
+}
+
+// deleteSleep will remove a sleep from the cache, it assumes that the
+// cache has already been locked
+func (c *memoryCache) deleteSleep(sleepId string) {
+	delete(c.sleeps, sleepId)
+	if c.eviction != nil {
+		c.eviction.delete(entryTypeSleep, sleepId)
+	}
+}
+
+// evict will remove entries from the cache according to the configured
+// eviction policy until the cache is within its configured limits, it
+// assumes that the cache has already been locked
+func (c *memoryCache) evict() {
+	if c.eviction == nil || !c.eviction.exceeds(c.config.maxEntries, c.config.maxSize) {
+		return
+	}
+	for _, entry := range c.eviction.candidates(c.config.evictionPolicy) {
+		if !c.eviction.exceeds(c.config.maxEntries, c.config.maxSize) {
+			return
+		}
+		//KIM: an entry may have already been removed because evicting
+		// an employee also evicts any searches that reference it
+		if !c.eviction.contains(entry.entryType, entry.key) {
+			continue
+		}
+		switch entry.entryType {
+		case entryTypeEmployee:
+			empNo, _ := strconv.ParseInt(entry.key, 10, 64)
+			c.deleteEmployee(empNo)
+			c.Trace(c.ctx, "evicted (employee): %d", empNo)
+			//KIM: a search can't be served without all of its employees
+			// so any search that references this employee is evicted too
+			c.deleteEmployeeSearches(empNo)
+		case entryTypeEmployeeSearch:
+			c.deleteEmployeeSearch(entry.key)
+			c.Trace(c.ctx, "evicted (employee_search): %s", entry.key)
+		case entryTypeSleep:
+			c.deleteSleep(entry.key)
+			c.Trace(c.ctx, "evicted (sleep): %s", entry.key)
+		}
+	}
+}
+
 func (c *memoryCache) Configure(envs map[string]string) error {
 	if s, ok := envs["CACHE_SET_READ_TTL"]; ok {
 		inProgressTTL, _ := strconv.Atoi(s)
@@ -257,6 +561,43 @@ func (c *memoryCache) Configure(envs map[string]string) error {
 		i, _ := strconv.ParseInt(s, 10, 64)
 		c.config.cacheTTL = time.Duration(i) * time.Second
 	}
+	if s, ok := envs["CACHE_HARD_TTL"]; ok {
+		i, _ := strconv.ParseInt(s, 10, 64)
+		c.config.hardTTL = time.Duration(i) * time.Second
+	}
+	if s, ok := envs["CACHE_TTL_JITTER"]; ok {
+		c.config.ttlJitter, _ = strconv.ParseFloat(s, 64)
+	}
+	if s, ok := envs["CACHE_XFETCH_BETA"]; ok {
+		c.config.xFetchBeta, _ = strconv.ParseFloat(s, 64)
+	}
+	if s, ok := envs["CACHE_MAX_ENTRIES"]; ok {
+		c.config.maxEntries, _ = strconv.Atoi(s)
+	}
+	if s, ok := envs["CACHE_MAX_SIZE"]; ok {
+		c.config.maxSize, _ = strconv.Atoi(s)
+	}
+	c.config.evictionPolicy = evictionPolicyLeastRecentlyUsed
+	if s, ok := envs["CACHE_EVICTION_POLICY"]; ok && s != "" {
+		policy, err := parseEvictionPolicy(s)
+		if err != nil {
+			return err
+		}
+		c.config.evictionPolicy = policy
+	}
+	if s, ok := envs["CACHE_SNAPSHOT_FILE"]; ok {
+		c.config.snapshotFile = s
+	}
+	if s, ok := envs["CACHE_SNAPSHOT_INTERVAL"]; ok {
+		i, _ := strconv.ParseInt(s, 10, 64)
+		c.config.snapshotInterval = time.Duration(i) * time.Second
+	}
+	c.config.fillTimeout = time.Minute
+	if s, ok := envs["CACHE_FILL_TIMEOUT"]; ok {
+		if i, _ := strconv.ParseInt(s, 10, 64); i > 0 {
+			c.config.fillTimeout = time.Duration(i) * time.Second
+		}
+	}
 	return nil
 }
 
@@ -266,19 +607,47 @@ func (c *memoryCache) Open(ctx context.Context) error {
 
 	c.employees = make(map[int64]cacheEmployee)
 	c.employeeSearches = make(map[string]cachedEmployeeSearch)
+	c.searchIndex = make(map[int64]map[string]struct{})
 	c.sleeps = make(map[string]cachedSleep)
+	c.generations.invalidations = make(map[string]memoryInvalidation)
 	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
 	c.launchPruneCache()
+	if c.config.hardTTL > c.config.cacheTTL {
+		c.Info(ctx, "cache: stale while revalidate enabled (%v)", c.config.hardTTL)
+	}
+	if c.config.maxEntries > 0 || c.config.maxSize > 0 {
+		c.eviction = newEvictionTracker()
+		c.Info(ctx, "cache: eviction enabled (%s)", c.config.evictionPolicy)
+	}
+	if c.config.snapshotFile != "" {
+		//KIM: a snapshot that can't be read (e.g. an older version) is
+		// ignored rather than preventing the cache from opening
+		snapshot, err := readSnapshot(c.config.snapshotFile)
+		switch {
+		case err != nil:
+			c.Error(ctx, "error while reading snapshot (%s), ignoring: %s",
+				c.config.snapshotFile, err)
+		case snapshot != nil:
+			nRestored := c.restore(snapshot)
+			c.Info(ctx, "cache: restored %d entries from snapshot (%s)",
+				nRestored, c.config.snapshotFile)
+		}
+		if c.config.snapshotInterval > 0 {
+			c.launchSnapshot()
+		}
+		c.Info(ctx, "cache: snapshot enabled (%s)", c.config.snapshotFile)
+	}
 	if c.config.inProgressEnabled {
-		c.inProgress.employeeRead = make(map[int64]int64)
-		c.inProgress.employeeSearch = make(map[string]int64)
-		c.inProgress.sleepRead = make(map[string]int64)
+		c.inProgress.employeeRead = make(map[int64]lease)
+		c.inProgress.employeeSearch = make(map[string]lease)
+		c.inProgress.sleepRead = make(map[string]lease)
+		c.waiters = newFillWaiters()
 		c.launchPruneSetRead()
 		c.Info(ctx, "cache: in progress enabled")
 	}
 	if c.config.notFoundEnabled {
 		c.notFound.employeeNotFound = make(map[int64]int64)
-		c.notFound.employeeSearchNotFound = make(map[string]int64)
+		c.notFound.employeeSearchNotFound = make(map[string]notFoundEmployeeSearch)
 		c.launchPruneNotFound()
 		c.Info(ctx, "cache: not found enabled")
 	}
@@ -286,11 +655,21 @@ func (c *memoryCache) Open(ctx context.Context) error {
 }
 
 func (c *memoryCache) Close(ctx context.Context) error {
+	//KIM: the background goroutines lock the cache, so they're stopped
+	// before the cache is locked
+	c.ctxCancel()
+	c.Wait()
+
 	c.Lock()
 	defer c.Unlock()
 
-	c.ctxCancel()
-	c.Wait()
+	if c.config.snapshotFile != "" {
+		if err := writeSnapshot(c.config.snapshotFile, c.snapshot()); err != nil {
+			c.Error(ctx, "error while writing snapshot (%s): %s", c.config.snapshotFile, err)
+			return err
+		}
+		c.Info(ctx, "cache: wrote snapshot (%s)", c.config.snapshotFile)
+	}
 	return nil
 }
 
@@ -301,11 +680,21 @@ func (c *memoryCache) Clear(ctx context.Context) error {
 	//clear cache
 	c.employees = make(map[int64]cacheEmployee)
 	c.employeeSearches = make(map[string]cachedEmployeeSearch)
+	c.searchIndex = make(map[int64]map[string]struct{})
 	c.sleeps = make(map[string]cachedSleep)
-	c.inProgress.employeeRead = make(map[int64]int64)
-	c.inProgress.employeeSearch = make(map[string]int64)
+	if c.eviction != nil {
+		c.eviction.clear()
+	}
+	c.inProgress.employeeRead = make(map[int64]lease)
+	c.inProgress.employeeSearch = make(map[string]lease)
+	c.inProgress.sleepRead = make(map[string]lease)
+	if c.config.inProgressEnabled {
+		c.waiters.notifyAll()
+	}
 	c.notFound.employeeNotFound = make(map[int64]int64)
-	c.notFound.employeeSearchNotFound = make(map[string]int64)
+	c.notFound.employeeSearchNotFound = make(map[string]notFoundEmployeeSearch)
+	c.generations.generation++
+	c.generations.cleared = c.generations.generation
 	return nil
 }
 
@@ -315,7 +704,11 @@ func (c *memoryCache) EmployeeRead(ctx context.Context, empNo int64) (*data.Empl
 
 	employee, ok := c.employees[empNo]
 	if ok {
-		return copyEmployee(employee.Employee), nil
+		if c.eviction != nil {
+			c.eviction.read(entryTypeEmployee, fmt.Sprint(empNo))
+		}
+		return copyEmployee(employee.Employee), employee.freshness(c.staleTTL(),
+			c.config.xFetchBeta).err(ErrEmployeeStale, ErrEmployeeRefresh)
 	}
 	if c.config.notFoundEnabled {
 		c.notFound.RLock()
@@ -327,10 +720,11 @@ func (c *memoryCache) EmployeeRead(ctx context.Context, empNo int64) (*data.Empl
 	if c.config.inProgressEnabled {
 		c.inProgress.Lock()
 		defer c.inProgress.Unlock()
-		if _, ok := c.inProgress.employeeRead[empNo]; ok {
+		l, ok := grantLease(c.inProgress.employeeRead, empNo, c.config.inProgressTTL)
+		if !ok {
 			return nil, ErrEmployeeReadAlreadySet
 		}
-		c.inProgress.employeeRead[empNo] = time.Now().UnixNano()
+		setLeaseCtx(ctx, l.token)
 		return nil, ErrEmployeeReadSet
 	}
 	return nil, ErrEmployeeNotCached
@@ -346,15 +740,28 @@ func (c *memoryCache) EmployeesRead(ctx context.Context, search data.EmployeeSea
 	}
 	employeeSearch, ok := c.employeeSearches[searchKey]
 	if ok {
+		f := employeeSearch.freshness(c.staleTTL(), c.config.xFetchBeta)
 		employees := make([]*data.Employee, 0, len(employeeSearch.empNos))
 		for empNo := range employeeSearch.empNos {
 			e, ok := c.employees[empNo]
 			if !ok {
-				continue
+				//KIM: employees expire independently of their searches, a
+				// search can't be served if any of its employees expired
+				employees = nil
+				break
 			}
+			f = max(f, e.freshness(c.staleTTL(), c.config.xFetchBeta))
 			employees = append(employees, copyEmployee(e.Employee))
+			if c.eviction != nil {
+				c.eviction.read(entryTypeEmployee, fmt.Sprint(empNo))
+			}
+		}
+		if c.eviction != nil {
+			c.eviction.read(entryTypeEmployeeSearch, searchKey)
+		}
+		if employees != nil {
+			return employees, f.err(ErrEmployeeSearchStale, ErrEmployeeSearchRefresh)
 		}
-		return employees, nil
 	}
 	if c.config.notFoundEnabled {
 		c.notFound.RLock()
@@ -366,10 +773,11 @@ func (c *memoryCache) EmployeesRead(ctx context.Context, search data.EmployeeSea
 	if c.config.inProgressEnabled {
 		c.inProgress.Lock()
 		defer c.inProgress.Unlock()
-		if _, ok := c.inProgress.employeeSearch[searchKey]; ok {
+		l, ok := grantLease(c.inProgress.employeeSearch, searchKey, c.config.inProgressTTL)
+		if !ok {
 			return nil, ErrEmployeesSearchAlreadySet
 		}
-		c.inProgress.employeeSearch[searchKey] = time.Now().UnixNano()
+		setLeaseCtx(ctx, l.token)
 		return nil, ErrEmployeesSearchSet
 	}
 	return nil, ErrEmployeeSearchNotCached
@@ -383,30 +791,65 @@ func (c *memoryCache) EmployeesWrite(ctx context.Context, search data.EmployeeSe
 	if err != nil {
 		return ErrSearchKey(err)
 	}
-	cachedAt := time.Now().UnixNano()
+	keys := make([]string, 0, len(employees))
+	leaseKeys := make([]int64, 0, len(employees))
+	for _, employee := range employees {
+		keys = append(keys, fmt.Sprint(employee.EmpNo))
+		leaseKeys = append(leaseKeys, employee.EmpNo)
+	}
+	if c.invalidated(ctx, entryTypeEmployeeSearch, searchKey) ||
+		c.invalidated(ctx, entryTypeEmployee, keys...) {
+		return ErrFillInvalidated
+	}
+	if c.config.inProgressEnabled {
+		c.inProgress.Lock()
+		defer c.inProgress.Unlock()
+		//KIM: writes that don't carry a lease (e.g. refreshes) are
+		// accepted, writes that do must still hold it
+		if token, ok := LeaseFromCtx(ctx); ok &&
+			!leaseHeld(c.inProgress.employeeSearch, token, c.config.inProgressTTL, searchKey) &&
+			!leaseHeld(c.inProgress.employeeRead, token, c.config.inProgressTTL, leaseKeys...) {
+			return ErrLeaseInvalid
+		}
+	}
 	empNos := make(map[int64]struct{})
 	for _, e := range employees {
 		employee := copyEmployee(e)
 		c.employees[employee.EmpNo] = cacheEmployee{
-			Employee: employee,
-			cachedAt: cachedAt,
+			Employee:    employee,
+			entryExpiry: c.newEntryExpiry(ctx),
 		}
 		empNos[employee.EmpNo] = struct{}{}
 		if c.config.inProgressEnabled {
 			delete(c.inProgress.employeeRead, e.EmpNo)
 		}
+		if c.eviction != nil {
+			c.eviction.write(entryTypeEmployee, fmt.Sprint(employee.EmpNo),
+				employeeSize(employee))
+		}
 	}
+	//KIM: the search may have been cached previously with a different
+	// set of employees, so it's removed from the search index first
+	c.deleteEmployeeSearch(searchKey)
 	c.employeeSearches[searchKey] = cachedEmployeeSearch{
-		empNos:   empNos,
-		cachedAt: cachedAt,
+		search:      search,
+		empNos:      empNos,
+		entryExpiry: c.newEntryExpiry(ctx),
+	}
+	for empNo := range empNos {
+		if _, ok := c.searchIndex[empNo]; !ok {
+			c.searchIndex[empNo] = make(map[string]struct{})
+		}
+		c.searchIndex[empNo][searchKey] = struct{}{}
+	}
+	if c.eviction != nil {
+		c.eviction.write(entryTypeEmployeeSearch, searchKey,
+			len(searchKey)+8*len(empNos))
 	}
 	if c.config.inProgressEnabled {
-		c.inProgress.Lock()
-		defer c.inProgress.Unlock()
 		delete(c.inProgress.employeeSearch, searchKey)
-		for empNo := range empNos {
-			delete(c.inProgress.employeeRead, empNo)
-		}
+		c.waiters.notify(entryTypeEmployeeSearch, searchKey)
+		c.waiters.notify(entryTypeEmployee, keys...)
 	}
 	if c.config.notFoundEnabled {
 		c.notFound.Lock()
@@ -416,6 +859,7 @@ func (c *memoryCache) EmployeesWrite(ctx context.Context, search data.EmployeeSe
 			delete(c.notFound.employeeNotFound, empNo)
 		}
 	}
+	c.evict()
 	return nil
 }
 
@@ -424,13 +868,16 @@ func (c *memoryCache) EmployeesDelete(ctx context.Context, empNos ...int64) erro
 	defer c.Unlock()
 
 	for _, empNo := range empNos {
-		delete(c.employees, empNo)
+		c.invalidate(entryTypeEmployee, fmt.Sprint(empNo))
+		c.deleteEmployee(empNo)
+		c.deleteEmployeeSearches(empNo)
 	}
 	if c.config.inProgressEnabled {
 		c.inProgress.Lock()
 		defer c.inProgress.Unlock()
 		for _, empNo := range empNos {
 			delete(c.inProgress.employeeRead, empNo)
+			c.waiters.notify(entryTypeEmployee, fmt.Sprint(empNo))
 		}
 	}
 	if c.config.notFoundEnabled {
@@ -458,11 +905,70 @@ func (c *memoryCache) EmployeesNotFoundWrite(ctx context.Context, search data.Em
 	c.notFound.Lock()
 	defer c.notFound.Unlock()
 	if _, ok := c.notFound.employeeSearchNotFound[searchKey]; !ok {
-		c.notFound.employeeSearchNotFound[searchKey] = tNow
+		c.notFound.employeeSearchNotFound[searchKey] = notFoundEmployeeSearch{
+			search:   search,
+			cachedAt: tNow,
+		}
 	}
 	for _, empNo := range empNos {
 		c.notFound.employeeNotFound[empNo] = tNow
 	}
+	//KIM: a fill that found nothing is complete, so anyone waiting on it
+	// can read the not found entry
+	if c.config.inProgressEnabled {
+		c.inProgress.Lock()
+		defer c.inProgress.Unlock()
+		delete(c.inProgress.employeeSearch, searchKey)
+		c.waiters.notify(entryTypeEmployeeSearch, searchKey)
+		for _, empNo := range empNos {
+			delete(c.inProgress.employeeRead, empNo)
+			c.waiters.notify(entryTypeEmployee, fmt.Sprint(empNo))
+		}
+	}
+	return nil
+}
+
+func (c *memoryCache) EmployeeSearchesRead(ctx context.Context) (map[string]data.EmployeeSearch, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	searches := make(map[string]data.EmployeeSearch)
+	for searchKey, employeeSearch := range c.employeeSearches {
+		searches[searchKey] = employeeSearch.search
+	}
+	if c.config.notFoundEnabled {
+		c.notFound.RLock()
+		defer c.notFound.RUnlock()
+		for searchKey, employeeSearch := range c.notFound.employeeSearchNotFound {
+			searches[searchKey] = employeeSearch.search
+		}
+	}
+	return searches, nil
+}
+
+func (c *memoryCache) EmployeeSearchesDelete(ctx context.Context, searchKeys ...string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	c.invalidate(entryTypeEmployeeSearch, searchKeys...)
+	for _, searchKey := range searchKeys {
+		c.deleteEmployeeSearch(searchKey)
+	}
+	if c.config.inProgressEnabled {
+		c.inProgress.Lock()
+		defer c.inProgress.Unlock()
+		for _, searchKey := range searchKeys {
+			delete(c.inProgress.employeeSearch, searchKey)
+		}
+		c.waiters.notify(entryTypeEmployeeSearch, searchKeys...)
+	}
+	if c.config.notFoundEnabled {
+		c.notFound.Lock()
+		defer c.notFound.Unlock()
+		for _, searchKey := range searchKeys {
+			delete(c.notFound.employeeSearchNotFound, searchKey)
+		}
+	}
 	return nil
 }
 
@@ -472,15 +978,20 @@ func (c *memoryCache) SleepRead(ctx context.Context, sleepId string) (*data.Slee
 
 	sleep, ok := c.sleeps[sleepId]
 	if ok {
-		return copySleep(sleep.Sleep), nil
+		if c.eviction != nil {
+			c.eviction.read(entryTypeSleep, sleepId)
+		}
+		return copySleep(sleep.Sleep), sleep.freshness(c.staleTTL(),
+			c.config.xFetchBeta).err(ErrSleepStale, ErrSleepRefresh)
 	}
 	if c.config.inProgressEnabled {
 		c.inProgress.Lock()
 		defer c.inProgress.Unlock()
-		if _, ok := c.inProgress.sleepRead[sleepId]; ok {
+		l, ok := grantLease(c.inProgress.sleepRead, sleepId, c.config.inProgressTTL)
+		if !ok {
 			return nil, ErrSleepReadAlreadySet
 		}
-		c.inProgress.sleepRead[sleepId] = time.Now().UnixNano()
+		setLeaseCtx(ctx, l.token)
 		return nil, ErrSleepReadSet
 	}
 	return nil, ErrSleepNotCached
@@ -490,13 +1001,29 @@ func (c *memoryCache) SleepWrite(ctx context.Context, s *data.Sleep) error {
 	c.Lock()
 	defer c.Unlock()
 
+	if c.invalidated(ctx, entryTypeSleep, s.Id) {
+		return ErrFillInvalidated
+	}
+	if c.config.inProgressEnabled {
+		c.inProgress.Lock()
+		defer c.inProgress.Unlock()
+		if token, ok := LeaseFromCtx(ctx); ok &&
+			!leaseHeld(c.inProgress.sleepRead, token, c.config.inProgressTTL, s.Id) {
+			return ErrLeaseInvalid
+		}
+	}
 	c.sleeps[s.Id] = cachedSleep{
-		Sleep:    copySleep(s),
-		cachedAt: time.Now().UnixNano(),
+		Sleep:       copySleep(s),
+		entryExpiry: c.newEntryExpiry(ctx),
 	}
 	if c.config.inProgressEnabled {
 		delete(c.inProgress.sleepRead, s.Id)
+		c.waiters.notify(entryTypeSleep, s.Id)
+	}
+	if c.eviction != nil {
+		c.eviction.write(entryTypeSleep, s.Id, sleepSize(s))
 	}
+	c.evict()
 	return nil
 }
 
@@ -504,8 +1031,9 @@ func (c *memoryCache) SleepsDelete(ctx context.Context, sleepIds ...string) erro
 	c.Lock()
 	defer c.Unlock()
 
+	c.invalidate(entryTypeSleep, sleepIds...)
 	for _, sleepId := range sleepIds {
-		delete(c.sleeps, sleepId)
+		c.deleteSleep(sleepId)
 	}
 	if c.config.inProgressEnabled {
 		c.inProgress.Lock()
@@ -513,6 +1041,145 @@ func (c *memoryCache) SleepsDelete(ctx context.Context, sleepIds ...string) erro
 		for _, sleepId := range sleepIds {
 			delete(c.inProgress.sleepRead, sleepId)
 		}
+		c.waiters.notify(entryTypeSleep, sleepIds...)
 	}
 	return nil
 }
+
+func (c *memoryCache) Generation(ctx context.Context) (int64, error) {
+	c.RLock()
+	defer c.RUnlock()
+
+	return c.generations.generation, nil
+}
+
+func (c *memoryCache) FillWait(ctx context.Context, entryType, key string) error {
+	var l lease
+	var ok bool
+
+	if !c.config.inProgressEnabled {
+		return ErrWaitUnsupported
+	}
+	c.inProgress.RLock()
+	switch entryType {
+	default:
+		c.inProgress.RUnlock()
+		return ErrCacheEntryTypeUnsupported
+	case entryTypeEmployee:
+		empNo, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			c.inProgress.RUnlock()
+			return err
+		}
+		l, ok = c.inProgress.employeeRead[empNo]
+	case entryTypeEmployeeSearch:
+		l, ok = c.inProgress.employeeSearch[key]
+	case entryTypeSleep:
+		l, ok = c.inProgress.sleepRead[key]
+	}
+	if !ok || l.expired(c.config.inProgressTTL) {
+		c.inProgress.RUnlock()
+		return nil
+	}
+	//KIM: the waiter is added while the lease is known to be held, the
+	// lease can't be released (and waiters notified) until it's unlocked
+	waiter, remove := c.waiters.add(entryType, key)
+	c.inProgress.RUnlock()
+	select {
+	case <-ctx.Done():
+		remove()
+		return ctx.Err()
+	case <-waiter:
+		return nil
+	}
+}
+
+func (c *memoryCache) KeysRead(ctx context.Context, entryType string) ([]data.CacheKey, error) {
+	var keys []data.CacheKey
+
+	types, err := entryTypes(entryType)
+	if err != nil {
+		return nil, err
+	}
+	c.RLock()
+	defer c.RUnlock()
+
+	staleTTL := c.staleTTL()
+	for _, entryType := range types {
+		switch entryType {
+		case entryTypeEmployee:
+			for empNo, employee := range c.employees {
+				keys = append(keys, newCacheKey(entryType,
+					strconv.FormatInt(empNo, 10), employee.entryExpiry, staleTTL))
+			}
+		case entryTypeEmployeeSearch:
+			for searchKey, employeeSearch := range c.employeeSearches {
+				keys = append(keys, newCacheKey(entryType,
+					searchKey, employeeSearch.entryExpiry, staleTTL))
+			}
+		case entryTypeSleep:
+			for sleepId, sleep := range c.sleeps {
+				keys = append(keys, newCacheKey(entryType,
+					sleepId, sleep.entryExpiry, staleTTL))
+			}
+		}
+	}
+	return keys, nil
+}
+
+func (c *memoryCache) EntryRead(ctx context.Context, entryType, key string) (*data.CacheEntry, error) {
+	var expiry entryExpiry
+	var value any
+
+	c.RLock()
+	defer c.RUnlock()
+
+	//KIM: reading an entry for inspection doesn't count as a read
+	// for the purposes of eviction
+	switch entryType {
+	default:
+		return nil, ErrCacheEntryTypeUnsupported
+	case entryTypeEmployee:
+		empNo, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		employee, ok := c.employees[empNo]
+		if !ok {
+			return nil, ErrCacheEntryNotFound
+		}
+		expiry, value = employee.entryExpiry, employee.Employee
+	case entryTypeEmployeeSearch:
+		employeeSearch, ok := c.employeeSearches[key]
+		if !ok {
+			return nil, ErrCacheEntryNotFound
+		}
+		empNos := make([]int64, 0, len(employeeSearch.empNos))
+		for empNo := range employeeSearch.empNos {
+			empNos = append(empNos, empNo)
+		}
+		sort.Slice(empNos, func(i, j int) bool { return empNos[i] < empNos[j] })
+		expiry, value = employeeSearch.entryExpiry, &storedEmployeeSearch{
+			Search: employeeSearch.search,
+			EmpNos: empNos,
+		}
+	case entryTypeSleep:
+		sleep, ok := c.sleeps[key]
+		if !ok {
+			return nil, ErrCacheEntryNotFound
+		}
+		expiry, value = sleep.entryExpiry, sleep.Sleep
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &data.CacheEntry{
+		CacheKey: newCacheKey(entryType, key, expiry, c.staleTTL()),
+		Value:    bytes,
+	}, nil
+}
+
+func (c *memoryCache) EntryDelete(ctx context.Context, entryType, key string) error {
+	return entryDelete(ctx, c, entryType, key)
+}
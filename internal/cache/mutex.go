@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/antonio-alexander/go-blog-cache/internal"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const keyMutexFencingToken string = "mutex_fencing_token"
+
+const (
+	// scriptMutexUnlock will only delete the mutex if it's still
+	// held by the provided token
+	scriptMutexUnlock string = `
+		if redis.call('GET', KEYS[1]) == ARGV[1] then
+			return redis.call('DEL', KEYS[1])
+		end
+		return 0`
+
+	// scriptMutexRenew will only extend the expiration of the mutex
+	// if it's still held by the provided token
+	scriptMutexRenew string = `
+		if redis.call('GET', KEYS[1]) == ARGV[1] then
+			return redis.call('PEXPIRE', KEYS[1], ARGV[2])
+		end
+		return 0`
+)
+
+type redisMutex struct {
+	sync.WaitGroup
+	redisClient     redis.UniversalClient
+	key             string
+	fencingTokenKey string
+	token           string
+	expiration      time.Duration
+	retryInterval   time.Duration
+	stopRenew       chan struct{}
+}
+
+func newRedisMutex(redisClient redis.UniversalClient, key, fencingTokenKey string, expiration, retryInterval time.Duration) *redisMutex {
+	return &redisMutex{
+		redisClient:     redisClient,
+		key:             key,
+		fencingTokenKey: fencingTokenKey,
+		expiration:      expiration,
+		retryInterval:   retryInterval,
+	}
+}
+
+// launchRenew will periodically extend the expiration of the mutex
+// so long critical sections don't lose the mutex while it's held
+func (m *redisMutex) launchRenew(token string) {
+	started := make(chan struct{})
+	m.stopRenew = make(chan struct{})
+	m.Add(1)
+	go func(stopRenew chan struct{}) {
+		defer m.Done()
+
+		tRenew := time.NewTicker(m.expiration / 3)
+		defer tRenew.Stop()
+		close(started)
+		for {
+			select {
+			case <-stopRenew:
+				return
+			case <-tRenew.C:
+				ctx, cancel := context.WithTimeout(context.Background(), m.expiration)
+				result, err := m.redisClient.Eval(ctx, scriptMutexRenew, []string{m.key},
+					token, m.expiration.Milliseconds()).Int64()
+				cancel()
+				if err == nil && result != 1 {
+					//KIM: the mutex expired or was taken by someone else
+					// so there's nothing left to renew
+					return
+				}
+			}
+		}
+	}(m.stopRenew)
+	<-started
+}
+
+// Lock will block until the mutex is acquired or the context is done,
+// once acquired it returns a fencing token that's greater than any
+// previously returned fencing token
+func (m *redisMutex) Lock(ctx context.Context) (int64, error) {
+	token := internal.GenerateId()
+	lockFx := func() (bool, error) {
+		return m.redisClient.SetNX(ctx, m.key, token, m.expiration).Result()
+	}
+	tRetry := time.NewTicker(m.retryInterval)
+	defer tRetry.Stop()
+	for {
+		acquired, err := lockFx()
+		if err != nil {
+			if ctx.Err() != nil {
+				return 0, ErrMutexNotAcquired
+			}
+			return 0, err
+		}
+		if acquired {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ErrMutexNotAcquired
+		case <-tRetry.C:
+		}
+	}
+	fencingToken, err := m.redisClient.Incr(ctx, m.fencingTokenKey).Result()
+	if err != nil {
+		_, _ = m.redisClient.Eval(ctx, scriptMutexUnlock, []string{m.key}, token).Result()
+		return 0, err
+	}
+	m.token = token
+	m.launchRenew(token)
+	return fencingToken, nil
+}
+
+// Unlock will release the mutex if it's still held, if the mutex has
+// expired or is held by someone else, an error is returned
+func (m *redisMutex) Unlock(ctx context.Context) error {
+	if m.stopRenew != nil {
+		close(m.stopRenew)
+		m.Wait()
+		m.stopRenew = nil
+	}
+	token := m.token
+	m.token = ""
+	if token == "" {
+		return ErrMutexNotHeld
+	}
+	result, err := m.redisClient.Eval(ctx, scriptMutexUnlock,
+		[]string{m.key}, token).Int64()
+	if err != nil {
+		return err
+	}
+	if result != 1 {
+		return ErrMutexNotHeld
+	}
+	return nil
+}
@@ -2,9 +2,10 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"net"
+	"math"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,27 +19,154 @@ import (
 )
 
 const (
-	hashKeyEmployees                string = "employees"
-	hashKeyEmployeesSearch          string = "employees_search"
-	hashKeySleep                    string = "sleep"
-	hashKeyInProgressEmployees      string = "in_progress_employees"
-	hashKeyInProgressSleeps         string = "in_progress_sleeps"
-	hashKeyInProgressEmployeesMutex string = "in_progress_employees_mutex"
-	hashKeyInProgressSleepsMutex    string = "in_progress_sleeps_mutex"
-	hashKeyNotFound                 string = "not_found_employees"
-	hashKeyNotFoundMutex            string = "not_found_mutex"
+	keyEmployees               string = "employees"
+	keyEmployeesSearch         string = "employees_search"
+	keyEmployeesSearchIndex    string = "employees_search_index"
+	keySleep                   string = "sleep"
+	hashKeyInProgressEmployees string = "in_progress_employees"
+	hashKeyInProgressSleeps    string = "in_progress_sleeps"
+	hashKeyNotFound            string = "not_found_employees"
+	hashKeyNotFoundSearches    string = "not_found_employees_searches"
+	hashKeyEmployeesSearches   string = "employees_searches"
+	keyFencingToken            string = "fencing_token"
+	keyGeneration              string = "generation"
+	keyInvalidated             string = "invalidated"
+	invalidatedAll             string = "all"
+	channelFilled              string = "filled"
+	filledAll                  string = "all"
 )
 
+// scriptConditionalSet will only set the values if none of the entries
+// they depend on were invalidated after the provided generation and if the
+// provided fencing token is at least as new as the fencing token last used
+// to set each value; an empty generation or fencing token skips that check.
+// The first ARGV[3] keys are the invalidations to check, followed by the
+// key and fencing token key of each value; the values and their expirations
+// follow the fencing token, generation and number of invalidations
+const scriptConditionalSet string = `
+	local fencing_token = tonumber(ARGV[1])
+	local generation = tonumber(ARGV[2])
+	local n = tonumber(ARGV[3])
+	if generation then
+		for i = 1, n do
+			local invalidated = tonumber(redis.call('GET', KEYS[i]))
+			if invalidated and invalidated > generation then
+				return -1
+			end
+		end
+	end
+	if fencing_token then
+		for i = n + 2, #KEYS, 2 do
+			local last_fencing_token = tonumber(redis.call('GET', KEYS[i]))
+			if last_fencing_token and last_fencing_token > fencing_token then
+				return 0
+			end
+		end
+	end
+	for i = n + 1, #KEYS, 2 do
+		local value, expiration = ARGV[i - n + 3], tonumber(ARGV[i - n + 4])
+		if expiration > 0 then
+			if fencing_token then
+				redis.call('SET', KEYS[i + 1], ARGV[1], 'PX', expiration)
+			end
+			redis.call('SET', KEYS[i], value, 'PX', expiration)
+		else
+			if fencing_token then
+				redis.call('SET', KEYS[i + 1], ARGV[1])
+			end
+			redis.call('SET', KEYS[i], value)
+		end
+	end
+	return 1`
+
+// results of the read or claim scripts
+const (
+	readOrClaimNotCached int64 = iota
+	readOrClaimValue
+	readOrClaimNotFound
+	readOrClaimGranted
+	readOrClaimHeld
+)
+
+// luaReadOrClaimArgs are the arguments of the read or claim scripts: the
+// field of the entry, the lease to grant, the current time and the in
+// progress and not found ttls (in nanoseconds), a ttl of zero disables
+// in progress (or not found)
+const luaReadOrClaimArgs string = `
+	local field, lease, now = ARGV[1], ARGV[2], tonumber(ARGV[3])
+	local in_progress_ttl, not_found_ttl = tonumber(ARGV[4]), tonumber(ARGV[5])`
+
+// luaClaim will grant the lease if a lease isn't held for the field or the
+// lease held has expired
+const luaClaim string = `
+	if in_progress_ttl <= 0 then
+		return {0}
+	end
+	local held = redis.call('HGET', in_progress_key, field)
+	if held then
+		local granted_at = tonumber(string.match(held, '^%d+'))
+		if granted_at and now - granted_at <= in_progress_ttl then
+			return {4}
+		end
+	end
+	redis.call('HSET', in_progress_key, field, lease)
+	redis.call('ZADD', in_progress_expiry_key, math.floor(now / 1000000), field)
+	return {3}`
+
+// scriptReadOrClaim will read the value, if there's no value it will check
+// if the field was cached as not found and otherwise claim its fill
+const scriptReadOrClaim string = luaReadOrClaimArgs + `
+	local in_progress_key, in_progress_expiry_key = KEYS[2], KEYS[3]
+	local not_found_key = KEYS[4]
+	local value = redis.call('GET', KEYS[1])
+	if value then
+		return {1, value}
+	end
+	if not_found_ttl > 0 then
+		local not_found = tonumber(redis.call('HGET', not_found_key, field))
+		if not_found and now - not_found <= not_found_ttl then
+			return {2}
+		end
+	end` + luaClaim
+
+// scriptClaim will claim the fill of the field
+const scriptClaim string = luaReadOrClaimArgs + `
+	local in_progress_key, in_progress_expiry_key = KEYS[1], KEYS[2]` + luaClaim
+
+// scriptPruneMarkers will delete the markers (fields of the hash) set at or
+// before the given time (in milliseconds) according to the sorted set that
+// tracks when each marker was set, the deleted fields are returned
+const scriptPruneMarkers string = `
+	local fields = redis.call('ZRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+	for i = 1, #fields, 1000 do
+		redis.call('HDEL', KEYS[1], unpack(fields, i, math.min(i + 999, #fields)))
+	end
+	redis.call('ZREMRANGEBYSCORE', KEYS[2], '-inf', ARGV[1])
+	return fields`
+
+// scriptInvalidate will increment the generation and record it as the
+// generation each of the given entries was invalidated at
+const scriptInvalidate string = `
+	local generation = redis.call('INCR', KEYS[1])
+	for i = 2, #KEYS do
+		redis.call('SET', KEYS[i], generation, 'PX', ARGV[1])
+	end
+	return generation`
+
 type redisCache struct {
 	sync.WaitGroup
-	redisClient *redis.Client
+	redisClient redis.UniversalClient
 	config      struct {
+		mode                    redisMode
 		address                 string
 		port                    string
 		password                string
 		database                int
+		sentinelMaster          string
+		sentinelAddresses       []string
+		sentinelPassword        string
+		clusterAddresses        []string
 		timeout                 time.Duration
-		mutexDisabled           bool
 		mutexExpiration         time.Duration
 		mutexRetryInterval      time.Duration
 		inProgressPruneInterval time.Duration
@@ -48,7 +176,16 @@ type redisCache struct {
 		notFoundTTL             time.Duration
 		notFoundEnabled         bool
 		cacheTTL                time.Duration
-	}
+		hardTTL                 time.Duration
+		ttlJitter               float64
+		xFetchBeta              float64
+		codec                   codecFormat
+		fillTimeout             time.Duration
+		keyPrefix               string
+		redisAuth
+	}
+	pubSub    *redis.PubSub
+	waiters   *fillWaiters
 	ctx       context.Context
 	ctxCancel context.CancelFunc
 	utilities.Logger
@@ -59,6 +196,9 @@ func NewRedis(parameters ...any) interface {
 	internal.Opener
 	internal.Clearer
 	Cache
+	Inspector
+	Versioner
+	Waiter
 } {
 	c := &redisCache{}
 	for _, parameter := range parameters {
@@ -76,46 +216,14 @@ func (c *redisCache) launchPruneSetRead() {
 	go func() {
 		defer c.Done()
 
-		pruneEmployeesSetFx := func() {
-			c.Lock(hashKeyInProgressEmployeesMutex)
-			defer c.Unlock(hashKeyInProgressEmployeesMutex)
-
-			var fieldsToDelete []string
-
-			hscanIter := c.redisClient.HScan(c.ctx, hashKeyInProgressEmployees, 0, "*", 0).Iterator()
-			for hscanIter.Next(c.ctx) {
-				field := hscanIter.Val()
-				t, _ := strconv.ParseInt(hscanIter.Val(), 10, 64)
-				if time.Since(time.Unix(0, t)) > c.config.inProgressTTL {
-					fieldsToDelete = append(fieldsToDelete, field)
-				}
-			}
-			if err := hscanIter.Err(); err != nil {
-				return
-			}
-			if len(fieldsToDelete) > 0 {
-				_, _ = c.redisClient.HDel(c.ctx, hashKeyInProgressEmployees, fieldsToDelete...).Result()
-			}
-		}
-		pruneSleepsSetFx := func() {
-			c.Lock(hashKeyInProgressSleepsMutex)
-			defer c.Unlock(hashKeyInProgressSleepsMutex)
-
-			var fieldsToDelete []string
-
-			hscanIter := c.redisClient.HScan(c.ctx, hashKeyInProgressSleeps, 0, "*", 0).Iterator()
-			for hscanIter.Next(c.ctx) {
-				field := hscanIter.Val()
-				t, _ := strconv.ParseInt(hscanIter.Val(), 10, 64)
-				if time.Since(time.Unix(0, t)) > c.config.inProgressTTL {
-					fieldsToDelete = append(fieldsToDelete, field)
-				}
-			}
-			if err := hscanIter.Err(); err != nil {
+		pruneFx := func(hashKey string, entryTypes ...string) {
+			fields, err := c.pruneMarkers(c.ctx, c.namespacedKey(hashKey), c.config.inProgressTTL)
+			if err != nil {
+				c.Trace(c.ctx, "error while pruning in progress (%s): %s", hashKey, err)
 				return
 			}
-			if len(fieldsToDelete) > 0 {
-				_, _ = c.redisClient.HDel(c.ctx, hashKeyInProgressSleeps, fieldsToDelete...).Result()
+			for _, entryType := range entryTypes {
+				c.filled(c.ctx, entryType, fields...)
 			}
 		}
 		tPrune := time.NewTicker(c.config.inProgressPruneInterval)
@@ -126,39 +234,55 @@ func (c *redisCache) launchPruneSetRead() {
 			case <-c.ctx.Done():
 				return
 			case <-tPrune.C:
-				pruneEmployeesSetFx()
-				pruneSleepsSetFx()
+				//KIM: employees and searches share the in progress hash, so
+				// it's not known which of the two each field is
+				pruneFx(hashKeyInProgressEmployees, entryTypeEmployee, entryTypeEmployeeSearch)
+				pruneFx(hashKeyInProgressSleeps, entryTypeSleep)
 			}
 		}
 	}()
 	<-started
 }
 
-func (c *redisCache) launchPruneNotFound() {
+// launchFillNotifications will notify local waiters of fills written (or
+// deleted) by any instance of the cache
+func (c *redisCache) launchFillNotifications() {
 	started := make(chan struct{})
 	c.Add(1)
 	go func() {
 		defer c.Done()
 
-		pruneFx := func() {
-			c.Lock(hashKeyNotFoundMutex)
-			defer c.Unlock(hashKeyNotFoundMutex)
-
-			var fieldsToDelete []string
-
-			hscanIter := c.redisClient.HScan(c.ctx, hashKeyNotFound, 0, "*", 0).Iterator()
-			for hscanIter.Next(c.ctx) {
-				field := hscanIter.Val()
-				t, _ := strconv.ParseInt(hscanIter.Val(), 10, 64)
-				if time.Since(time.Unix(0, t)) > c.config.notFoundTTL {
-					fieldsToDelete = append(fieldsToDelete, field)
+		chMessage := c.pubSub.Channel()
+		close(started)
+		for message := range chMessage {
+			if message.Payload == filledAll {
+				c.waiters.notifyAll()
+				continue
+			}
+			for _, entry := range strings.Split(message.Payload, "\n") {
+				if entryType, key, ok := strings.Cut(entry, ":"); ok {
+					c.waiters.notify(entryType, key)
 				}
 			}
-			if err := hscanIter.Err(); err != nil {
+		}
+	}()
+	<-started
+}
+
+func (c *redisCache) launchPruneNotFound() {
+	started := make(chan struct{})
+	c.Add(1)
+	go func() {
+		defer c.Done()
+
+		pruneFx := func() {
+			fields, err := c.pruneMarkers(c.ctx, c.namespacedKey(hashKeyNotFound), c.config.notFoundTTL)
+			if err != nil {
+				c.Trace(c.ctx, "error while pruning not found: %s", err)
 				return
 			}
-			if len(fieldsToDelete) > 0 {
-				_, _ = c.redisClient.HDel(c.ctx, hashKeyNotFound, fieldsToDelete...).Result()
+			if len(fields) > 0 {
+				_, _ = c.redisClient.HDel(c.ctx, c.namespacedKey(hashKeyNotFoundSearches), fields...).Result()
 			}
 		}
 		tPrune := time.NewTicker(c.config.notFoundPruneInterval)
@@ -176,69 +300,453 @@ func (c *redisCache) launchPruneNotFound() {
 	<-started
 }
 
-func (r *redisCache) Lock(hashKey string) {
-	if r.config.mutexDisabled {
-		return
+// NewMutex returns a distributed mutex for the given key, each holder
+// of the mutex has a unique token so only the holder can unlock it
+func (c *redisCache) NewMutex(key string) Mutex {
+	return newRedisMutex(c.redisClient, c.namespacedKey(key),
+		c.namespacedKey(keyMutexFencingToken), c.config.mutexExpiration,
+		c.config.mutexRetryInterval)
+}
+
+// staleTTL returns how long an entry can be served stale once it's
+// past its soft ttl, entries can only be served stale if a hard ttl
+// is configured
+func (c *redisCache) staleTTL() time.Duration {
+	if c.config.hardTTL > c.config.cacheTTL {
+		return c.config.hardTTL - c.config.cacheTTL
+	}
+	return 0
+}
+
+// get will read and decode the value for the given key
+func (c *redisCache) get(ctx context.Context, key string, v any) error {
+	entry, err := c.getEntry(ctx, key)
+	if err != nil {
+		return err
 	}
-	lockFx := func() bool {
-		result, err := r.redisClient.SetNX(r.ctx, hashKey,
-			true, r.config.mutexExpiration).Result()
+	return entry.decode(v)
+}
+
+// getEntry will read the entry (the encoded value and its metadata)
+// for the given key
+func (c *redisCache) getEntry(ctx context.Context, key string) (*storedEntry, error) {
+	bytes, err := c.redisClient.Get(ctx, key).Bytes()
+	if err != nil {
+		return nil, err
+	}
+	return decodeEntry(bytes)
+}
+
+// getEntries will read the entries for the given keys in a single round
+// trip, the entry of any key that doesn't exist is nil
+func (c *redisCache) getEntries(ctx context.Context, keys ...string) ([]*storedEntry, error) {
+	values := make([]any, 0, len(keys))
+	if c.config.mode != redisModeCluster {
+		result, err := c.redisClient.MGet(ctx, keys...).Result()
 		if err != nil {
-			return false
+			return nil, err
+		}
+		values = result
+	} else {
+		cmds, err := c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, key := range keys {
+				pipe.Get(ctx, key)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, err
+		}
+		for _, cmd := range cmds {
+			value, err := cmd.(*redis.StringCmd).Result()
+			if err != nil {
+				if !errors.Is(err, redis.Nil) {
+					return nil, err
+				}
+				values = append(values, nil)
+				continue
+			}
+			values = append(values, value)
 		}
-		return result
 	}
-	if lockFx() {
-		return
+	entries := make([]*storedEntry, 0, len(values))
+	for _, value := range values {
+		value, ok := value.(string)
+		if !ok {
+			entries = append(entries, nil)
+			continue
+		}
+		entry, err := decodeEntry([]byte(value))
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
 	}
-	tRetry := time.NewTicker(r.config.mutexRetryInterval)
-	defer tRetry.Stop()
-	for {
-		select {
-		case <-tRetry.C:
-			if lockFx() {
-				return
+	return entries, nil
+}
+
+// redisEntry is a value to set and the keys of the entries it depends on
+type redisEntry struct {
+	key          string
+	value        any
+	dependencies []string
+}
+
+// set will set the value for the given key, see setEntries
+func (c *redisCache) set(ctx context.Context, key string, v any, dependencies ...string) error {
+	return c.setEntries(ctx, redisEntry{
+		key:          key,
+		value:        v,
+		dependencies: dependencies,
+	})
+}
+
+// setEntries will encode the values with the configured codec and write them
+// with the cache ttl, all or none of the values are written (outside of a
+// cluster); if a generation
+// is attached to the context, the write will be rejected if any key (or any
+// key it depends on) was invalidated after that generation and if a fencing
+// token is attached to the context, the write will be rejected if any key was
+// previously written with a newer fencing token
+func (c *redisCache) setEntries(ctx context.Context, entries ...redisEntry) error {
+	var invalidationKeys []string
+
+	fencingToken, fenced := FencingTokenFromCtx(ctx)
+	generation, versioned := GenerationFromCtx(ctx)
+	args := []any{"", "", 0}
+	if fenced {
+		args[0] = fencingToken
+	}
+	if versioned {
+		args[1] = generation
+	}
+	keys := make([]string, 0, 2*len(entries))
+	invalidated := map[string]struct{}{c.derivedKey(keyInvalidated, invalidatedAll): {}}
+	for _, entry := range entries {
+		expiry := newEntryExpiry(ctx, c.config.cacheTTL, c.config.ttlJitter)
+		bytes, err := encodeEntry(c.config.codec, expiry, entry.value)
+		if err != nil {
+			return err
+		}
+		if len(entries) == 1 && !fenced && !versioned {
+			return c.redisClient.Set(ctx, entry.key, bytes, expiry.expiration(c.staleTTL())).Err()
+		}
+		keys = append(keys, entry.key, c.derivedKey(keyFencingToken, entry.key))
+		args = append(args, bytes, expiry.expiration(c.staleTTL()).Milliseconds())
+		for _, key := range append([]string{entry.key}, entry.dependencies...) {
+			invalidated[c.derivedKey(keyInvalidated, key)] = struct{}{}
+		}
+	}
+	for key := range invalidated {
+		invalidationKeys = append(invalidationKeys, key)
+	}
+	if c.config.mode == redisModeCluster {
+		return c.setEntriesCluster(ctx, entries, keys, args, invalidationKeys)
+	}
+	args[2] = len(invalidationKeys)
+	result, err := c.redisClient.Eval(ctx, scriptConditionalSet,
+		append(invalidationKeys, keys...), args...).Int64()
+	if err != nil {
+		return err
+	}
+	return conditionalSetResult(result)
+}
+
+// setEntriesCluster will set the entries one script per entry since the
+// keys used by a script must hash to the same slot; invalidations of anything
+// other than each entry's key are checked before the scripts (anything
+// invalidated in between is deleted after it's invalidated so it won't be
+// served); the writes aren't atomic, but entries that depend on other entries
+// are only set once those entries have been
+func (c *redisCache) setEntriesCluster(ctx context.Context, entries []redisEntry, keys []string, args []any, invalidationKeys []string) error {
+	if generation, versioned := GenerationFromCtx(ctx); versioned {
+		invalidated, err := c.invalidatedSince(ctx, generation, invalidationKeys...)
+		if err != nil {
+			return err
+		}
+		if invalidated {
+			return ErrFillInvalidated
+		}
+	}
+	for _, dependents := range []bool{false, true} {
+		cmds, err := c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			for i, entry := range entries {
+				if (len(entry.dependencies) > 0) != dependents {
+					continue
+				}
+				entryArgs := []any{args[0], args[1], 1, args[3+2*i], args[4+2*i]}
+				pipe.Eval(ctx, scriptConditionalSet, []string{
+					c.derivedKey(keyInvalidated, entry.key), keys[2*i], keys[2*i+1],
+				}, entryArgs...)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, cmd := range cmds {
+			result, err := cmd.(*redis.Cmd).Int64()
+			if err != nil {
+				return err
+			}
+			if err := conditionalSetResult(result); err != nil {
+				return err
 			}
-		case <-r.ctx.Done():
-			return
 		}
 	}
+	return nil
 }
 
-func (r *redisCache) Unlock(hashKey string) {
-	const script = `
-			local key = KEYS[1]
-			local expected_value = ARGV[1]
+// conditionalSetResult returns the error for the result of the conditional
+// set script, if any
+func conditionalSetResult(result int64) error {
+	switch result {
+	case 0:
+		return ErrFencingTokenStale
+	case -1:
+		return ErrFillInvalidated
+	}
+	return nil
+}
 
-			local current_value = redis.call('GET', key)
+// invalidate will increment the generation and record it as the generation
+// the given keys were invalidated at, the record is kept long enough for any
+// fill in progress to see it
+func (c *redisCache) invalidate(ctx context.Context, keys ...string) error {
+	invalidationKeys := []string{c.namespacedKey(keyGeneration)}
+	for _, key := range keys {
+		invalidationKeys = append(invalidationKeys, c.derivedKey(keyInvalidated, key))
+	}
+	if c.config.mode != redisModeCluster {
+		return c.redisClient.Eval(ctx, scriptInvalidate, invalidationKeys,
+			c.config.fillTimeout.Milliseconds()).Err()
+	}
+	//KIM: in a cluster, the invalidations are in different slots than the
+	// generation, so they're recorded once the generation is incremented
+	generation, err := c.redisClient.Incr(ctx, c.namespacedKey(keyGeneration)).Result()
+	if err != nil {
+		return err
+	}
+	_, err = c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range invalidationKeys[1:] {
+			pipe.Set(ctx, key, generation, c.config.fillTimeout)
+		}
+		return nil
+	})
+	return err
+}
 
-			if current_value == expected_value then
-			    return redis.call('DEL', key)
-			else
-		    	return 0 -- Key not deleted (value did not match)
-			end
-		`
+// invalidatedSince returns true if any of the given invalidations were
+// recorded after the given generation
+func (c *redisCache) invalidatedSince(ctx context.Context, generation int64, invalidationKeys ...string) (bool, error) {
+	cmds, err := c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range invalidationKeys {
+			pipe.Get(ctx, key)
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return false, err
+	}
+	for _, cmd := range cmds {
+		invalidated, err := cmd.(*redis.StringCmd).Int64()
+		if err != nil {
+			if errors.Is(err, redis.Nil) {
+				continue
+			}
+			return false, err
+		}
+		if invalidated > generation {
+			return true, nil
+		}
+	}
+	return false, nil
+}
 
-	if r.config.mutexDisabled {
-		return
+// readOrClaim will read the entry for the given key, if it's not cached
+// it will check if the field was cached as not found and otherwise claim
+// the fill of the field, the lease granted is attached to the context; the
+// not found key is optional. Outside of a cluster this is done with a single
+// script, in a cluster the keys are in different slots so the entry and not
+// found are read (pipelined) before the fill is claimed
+func (c *redisCache) readOrClaim(ctx context.Context, key, inProgressKey, notFoundKey, field string) (*storedEntry, int64, error) {
+	var notFoundTTL time.Duration
+	var value string
+	var result int64
+
+	if c.config.notFoundEnabled && notFoundKey != "" {
+		notFoundTTL = c.config.notFoundTTL
 	}
-	item, err := r.redisClient.Eval(r.ctx, script,
-		[]string{hashKey}, true).Result()
+	if c.config.mode != redisModeCluster {
+		keys := []string{key, inProgressKey, expiryKey(inProgressKey)}
+		if notFoundKey != "" {
+			keys = append(keys, notFoundKey)
+		}
+		l := newLease()
+		values, err := c.redisClient.Eval(ctx, scriptReadOrClaim, keys,
+			c.readOrClaimArgs(l, field, notFoundTTL)...).Slice()
+		if err != nil {
+			return nil, 0, err
+		}
+		result, _ = values[0].(int64)
+		if len(values) > 1 {
+			value, _ = values[1].(string)
+		}
+		if result == readOrClaimGranted {
+			setLeaseCtx(ctx, l.token)
+		}
+	} else {
+		cmds, err := c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Get(ctx, key)
+			if notFoundTTL > 0 {
+				pipe.HGet(ctx, notFoundKey, field)
+			}
+			return nil
+		})
+		if err != nil && !errors.Is(err, redis.Nil) {
+			return nil, 0, err
+		}
+		value, err = cmds[0].(*redis.StringCmd).Result()
+		switch {
+		case err == nil:
+			result = readOrClaimValue
+		case notFoundTTL > 0 && notFoundSince(cmds[1].(*redis.StringCmd)) <= notFoundTTL:
+			result = readOrClaimNotFound
+		default:
+			if result, err = c.claim(ctx, inProgressKey, field); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+	if result != readOrClaimValue {
+		return nil, result, nil
+	}
+	entry, err := decodeEntry([]byte(value))
 	if err != nil {
-		return
+		return nil, 0, err
+	}
+	return entry, result, nil
+}
+
+// notFoundSince returns how long ago the not found was cached, if it
+// wasn't cached the duration is the maximum duration
+func notFoundSince(cmd *redis.StringCmd) time.Duration {
+	t, err := cmd.Int64()
+	if err != nil {
+		return time.Duration(math.MaxInt64)
+	}
+	return time.Since(time.Unix(0, t))
+}
+
+// claim will claim the fill of the field, the lease granted is attached
+// to the context
+func (c *redisCache) claim(ctx context.Context, inProgressKey, field string) (int64, error) {
+	l := newLease()
+	result, err := c.redisClient.Eval(ctx, scriptClaim,
+		[]string{inProgressKey, expiryKey(inProgressKey)},
+		c.readOrClaimArgs(l, field, 0)...).Slice()
+	if err != nil {
+		return 0, err
+	}
+	if result[0] == readOrClaimGranted {
+		setLeaseCtx(ctx, l.token)
+	}
+	claimResult, _ := result[0].(int64)
+	return claimResult, nil
+}
+
+func (c *redisCache) readOrClaimArgs(l lease, field string, notFoundTTL time.Duration) []any {
+	var inProgressTTL time.Duration
+
+	if c.config.inProgressEnabled {
+		inProgressTTL = c.config.inProgressTTL
+	}
+	return []any{field, l.String(), l.grantedAt, inProgressTTL.Nanoseconds(),
+		notFoundTTL.Nanoseconds()}
+}
+
+// leaseHeld returns true if the token holds an unexpired lease for any
+// of the fields of the in progress hash
+func (c *redisCache) leaseHeld(ctx context.Context, hashKey, token string, fields ...string) (bool, error) {
+	values, err := c.redisClient.HMGet(ctx, hashKey, fields...).Result()
+	if err != nil {
+		return false, err
+	}
+	for _, value := range values {
+		if value, ok := value.(string); ok {
+			if l := parseLease(value); l.token == token && !l.expired(c.config.inProgressTTL) {
+				return true, nil
+			}
+		}
 	}
-	i, ok := item.(int64)
-	if !ok {
+	return false, nil
+}
+
+// setMarkers will set the markers (fields of the hash) unless they're
+// already set and track when they were set so they can be pruned
+func (c *redisCache) setMarkers(ctx context.Context, hashKey string, t time.Time, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	_, err := c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		members := make([]redis.Z, 0, len(fields))
+		for _, field := range fields {
+			pipe.HSetNX(ctx, hashKey, field, fmt.Sprint(t.UnixNano()))
+			members = append(members, redis.Z{Score: float64(t.UnixMilli()), Member: field})
+		}
+		pipe.ZAddNX(ctx, expiryKey(hashKey), members...)
+		return nil
+	})
+	return err
+}
+
+// deleteMarkers will delete the markers (fields of the hash) and stop
+// tracking when they were set
+func (c *redisCache) deleteMarkers(ctx context.Context, hashKey string, fields ...string) error {
+	if len(fields) == 0 {
+		return nil
+	}
+	_, err := c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		members := make([]any, 0, len(fields))
+		for _, field := range fields {
+			members = append(members, field)
+		}
+		pipe.HDel(ctx, hashKey, fields...)
+		pipe.ZRem(ctx, expiryKey(hashKey), members...)
+		return nil
+	})
+	return err
+}
+
+// pruneMarkers will delete the markers (fields of the hash) set longer
+// than the ttl ago, the deleted fields are returned
+func (c *redisCache) pruneMarkers(ctx context.Context, hashKey string, ttl time.Duration) ([]string, error) {
+	return c.redisClient.Eval(ctx, scriptPruneMarkers,
+		[]string{hashKey, expiryKey(hashKey)},
+		time.Now().Add(-ttl).UnixMilli()).StringSlice()
+}
+
+// filled will publish that the given entries were written (or deleted), so
+// that any readers waiting on their fills are notified; the entries are
+// published as a single message (one entry per line)
+func (c *redisCache) filled(ctx context.Context, entryType string, keys ...string) {
+	if len(keys) == 0 {
 		return
 	}
-	if i != 1 {
-		panic("attempted to unlock an unlocked mutex")
+	entries := make([]string, 0, len(keys))
+	for _, key := range keys {
+		entries = append(entries, fillWaiterKey(entryType, key))
+	}
+	if err := c.redisClient.Publish(ctx, c.namespacedKey(channelFilled),
+		strings.Join(entries, "\n")).Err(); err != nil {
+		c.Trace(ctx, "error while publishing fill (%s): %s", entryType, err)
 	}
 }
 
 func (c *redisCache) Configure(envs map[string]string) error {
 	c.config.mutexExpiration = 10 * time.Second
 	c.config.mutexRetryInterval = time.Second
+	c.config.codec = codecFormatJSON
 	if s, ok := envs["CACHE_PRUNE_INTERVAL"]; ok {
 		inProgressPruneInterval, _ := strconv.Atoi(s)
 		c.config.inProgressPruneInterval = time.Second * time.Duration(inProgressPruneInterval)
@@ -259,10 +767,29 @@ func (c *redisCache) Configure(envs map[string]string) error {
 	if redisPassword, ok := envs["REDIS_PASSWORD"]; ok {
 		c.config.password = redisPassword
 	}
+	c.config.redisAuth.configure(envs)
 	if redisDatabase, ok := envs["REDIS_DATABASE"]; ok {
 		i, _ := strconv.ParseInt(redisDatabase, 10, 64)
 		c.config.database = int(i)
 	}
+	mode, err := parseRedisMode(envs["REDIS_MODE"])
+	if err != nil {
+		return err
+	}
+	c.config.mode = mode
+	if s, ok := envs["REDIS_SENTINEL_MASTER"]; ok {
+		c.config.sentinelMaster = s
+	}
+	if s, ok := envs["REDIS_SENTINEL_ADDRESSES"]; ok {
+		c.config.sentinelAddresses = parseAddresses(s)
+	}
+	if s, ok := envs["REDIS_SENTINEL_PASSWORD"]; ok {
+		c.config.sentinelPassword = s
+	}
+	if s, ok := envs["REDIS_CLUSTER_ADDRESSES"]; ok {
+		c.config.clusterAddresses = parseAddresses(s)
+	}
+	c.config.timeout = 10 * time.Second
 	if redisTimeout, ok := envs["REDIS_TIMEOUT"]; ok {
 		i, _ := strconv.ParseInt(redisTimeout, 10, 64)
 		c.config.timeout = time.Duration(i) * time.Second
@@ -275,6 +802,12 @@ func (c *redisCache) Configure(envs map[string]string) error {
 		mutexRetryInterval, _ := strconv.Atoi(s)
 		c.config.mutexRetryInterval = time.Second * time.Duration(mutexRetryInterval)
 	}
+	if c.config.mutexExpiration <= 0 {
+		c.config.mutexExpiration = 10 * time.Second
+	}
+	if c.config.mutexRetryInterval <= 0 {
+		c.config.mutexRetryInterval = time.Second
+	}
 	if s, ok := envs["CACHE_PRUNE_INTERVAL"]; ok {
 		inProgressPruneInterval, _ := strconv.Atoi(s)
 		c.config.inProgressPruneInterval = time.Second * time.Duration(inProgressPruneInterval)
@@ -300,48 +833,153 @@ func (c *redisCache) Configure(envs map[string]string) error {
 	if notFoundEnabled, ok := envs["CACHE_NOT_FOUND_ENABLED"]; ok {
 		c.config.notFoundEnabled, _ = strconv.ParseBool(notFoundEnabled)
 	}
-	if mutexDisabled, ok := envs["CACHE_REDIS_MUTEX_DISABLED"]; ok {
-		c.config.mutexDisabled, _ = strconv.ParseBool(mutexDisabled)
-	}
 	c.config.cacheTTL = 5 * time.Second
 	if s, ok := envs["CACHE_TTL"]; ok {
 		i, _ := strconv.ParseInt(s, 10, 64)
 		c.config.cacheTTL = time.Duration(i) * time.Second
 	}
+	if s, ok := envs["CACHE_HARD_TTL"]; ok {
+		i, _ := strconv.ParseInt(s, 10, 64)
+		c.config.hardTTL = time.Duration(i) * time.Second
+	}
+	if s, ok := envs["CACHE_TTL_JITTER"]; ok {
+		c.config.ttlJitter, _ = strconv.ParseFloat(s, 64)
+	}
+	if s, ok := envs["CACHE_XFETCH_BETA"]; ok {
+		c.config.xFetchBeta, _ = strconv.ParseFloat(s, 64)
+	}
+	c.config.fillTimeout = time.Minute
+	if s, ok := envs["CACHE_FILL_TIMEOUT"]; ok {
+		if i, _ := strconv.ParseInt(s, 10, 64); i > 0 {
+			c.config.fillTimeout = time.Duration(i) * time.Second
+		}
+	}
+	if s, ok := envs["CACHE_CODEC"]; ok && s != "" {
+		codec, err := parseCodecFormat(s)
+		if err != nil {
+			return err
+		}
+		c.config.codec = codec
+	}
+	if s, ok := envs["CACHE_KEY_PREFIX"]; ok && s != "" {
+		keyPrefix, err := parseKeyPrefix(s)
+		if err != nil {
+			return err
+		}
+		c.config.keyPrefix = keyPrefix
+	}
 	return nil
 }
 
-func (c *redisCache) setExpiration() error {
-	if _, err := c.redisClient.Expire(c.ctx, hashKeyEmployees,
-		c.config.cacheTTL).Result(); err != nil {
+// deleteKeys will scan and delete all keys with the given prefix
+func (c *redisCache) deleteKeys(ctx context.Context, prefix string) error {
+	keys, err := c.scan(ctx, c.namespacedKey(prefix)+":*")
+	if err != nil {
 		return err
 	}
-	if _, err := c.redisClient.Expire(c.ctx, hashKeyEmployeesSearch,
-		c.config.cacheTTL).Result(); err != nil {
+	return c.del(ctx, keys...)
+}
+
+// indexEmployeesSearch will add the search key to the search index of
+// each employee, the index is kept at least as long as the search
+func (c *redisCache) indexEmployeesSearch(ctx context.Context, searchKey string, empNos ...int64) error {
+	expiration := c.maxExpiration()
+	_, err := c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, empNo := range empNos {
+			key := c.key(keyEmployeesSearchIndex, empNo)
+			pipe.SAdd(ctx, key, searchKey)
+			if expiration > 0 {
+				pipe.PExpire(ctx, key, expiration)
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// maxExpiration returns the longest an entry can be kept, zero (or less)
+// if entries don't expire
+func (c *redisCache) maxExpiration() time.Duration {
+	return c.config.cacheTTL + time.Duration(c.config.ttlJitter*float64(c.config.cacheTTL)) +
+		c.staleTTL()
+}
+
+// registerEmployeesSearch will record the search (and its criteria) so the
+// cached searches can be read without scanning for their keys, it's
+// tracked like a marker so it can be pruned once the search has expired
+func (c *redisCache) registerEmployeesSearch(ctx context.Context, searchKey string, search data.EmployeeSearch) error {
+	bytes, err := search.MarshalBinary()
+	if err != nil {
 		return err
 	}
-	if _, err := c.redisClient.Expire(c.ctx, hashKeySleep,
-		c.config.cacheTTL).Result(); err != nil {
+	hashKey := c.namespacedKey(hashKeyEmployeesSearches)
+	_, err = c.redisClient.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, hashKey, searchKey, bytes)
+		pipe.ZAdd(ctx, expiryKey(hashKey), redis.Z{
+			Score:  float64(time.Now().UnixMilli()),
+			Member: searchKey,
+		})
+		return nil
+	})
+	return err
+}
+
+// deleteEmployeesSearches will use the search index to delete any
+// employee search that references the given employees, the indexes are
+// read in a single pipeline (they may be in different slots so SUNION
+// can't be used in a cluster)
+func (c *redisCache) deleteEmployeesSearches(ctx context.Context, empNos ...int64) error {
+	var keys, registered []string
+
+	if len(empNos) <= 0 {
+		return nil
+	}
+	indexKeys := make([]string, 0, len(empNos))
+	cmds := make([]*redis.StringSliceCmd, 0, len(empNos))
+	if _, err := c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, empNo := range empNos {
+			key := c.key(keyEmployeesSearchIndex, empNo)
+			indexKeys = append(indexKeys, key)
+			cmds = append(cmds, pipe.SMembers(ctx, key))
+		}
+		return nil
+	}); err != nil {
 		return err
 	}
-	return nil
+	searchKeys := make(map[string]struct{})
+	for _, cmd := range cmds {
+		for _, searchKey := range cmd.Val() {
+			if _, ok := searchKeys[searchKey]; ok {
+				continue
+			}
+			searchKeys[searchKey] = struct{}{}
+			keys = append(keys, c.key(keyEmployeesSearch, searchKey))
+			registered = append(registered, searchKey)
+		}
+	}
+	if err := c.del(ctx, append(keys, indexKeys...)...); err != nil {
+		return err
+	}
+	return c.deleteMarkers(ctx, c.namespacedKey(hashKeyEmployeesSearches), registered...)
 }
 
 func (c *redisCache) Open(ctx context.Context) error {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     net.JoinHostPort(c.config.address, c.config.port),
-		Password: c.config.password,
-		DB:       c.config.database,
-	})
+	redisClient, err := c.newClient()
+	if err != nil {
+		return err
+	}
 	if err := redisClient.Ping(context.Background()).Err(); err != nil {
 		return err
 	}
 	c.redisClient = redisClient
 	c.ctx, c.ctxCancel = context.WithCancel(context.Background())
-	if err := c.setExpiration(); err != nil {
-		return err
-	}
 	if c.config.inProgressEnabled {
+		c.waiters = newFillWaiters()
+		c.pubSub = c.redisClient.Subscribe(c.ctx, c.namespacedKey(channelFilled))
+		if _, err := c.pubSub.Receive(ctx); err != nil {
+			return err
+		}
+		c.launchFillNotifications()
 		c.launchPruneSetRead()
 		c.Info(ctx, "cache: in progress enabled")
 	}
@@ -349,8 +987,8 @@ func (c *redisCache) Open(ctx context.Context) error {
 		c.launchPruneNotFound()
 		c.Info(ctx, "cache: not found enabled")
 	}
-	if c.config.mutexDisabled {
-		c.Info(ctx, "cache: redis mutex disabled")
+	if c.config.hardTTL > c.config.cacheTTL {
+		c.Info(ctx, "cache: stale while revalidate enabled (%v)", c.config.hardTTL)
 	}
 	return nil
 }
@@ -358,6 +996,9 @@ func (c *redisCache) Open(ctx context.Context) error {
 func (c *redisCache) Close(ctx context.Context) error {
 	if c.config.inProgressEnabled {
 		c.ctxCancel()
+		if err := c.pubSub.Close(); err != nil {
+			c.Error(ctx, "error while closing redis subscription: %s", err)
+		}
 		c.Wait()
 	}
 	if err := c.redisClient.Close(); err != nil {
@@ -369,59 +1010,57 @@ func (c *redisCache) Close(ctx context.Context) error {
 func (c *redisCache) Clear(ctx context.Context) error {
 	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
 	defer cancel()
-	if _, err := c.redisClient.Del(ctx, hashKeyEmployees).Result(); err != nil {
+	if err := c.invalidate(ctx, invalidatedAll); err != nil {
 		return err
 	}
-	if _, err := c.redisClient.Del(ctx, hashKeyEmployeesSearch).Result(); err != nil {
-		return err
-	}
-	if _, err := c.redisClient.Del(ctx, hashKeyInProgressEmployees).Result(); err != nil {
-		return err
+	for _, prefix := range []string{keyEmployees, keyEmployeesSearch,
+		keyEmployeesSearchIndex, keySleep} {
+		if err := c.deleteKeys(ctx, prefix); err != nil {
+			return err
+		}
 	}
-	if _, err := c.redisClient.Del(ctx, hashKeyInProgressEmployeesMutex).Result(); err != nil {
-		return err
+	inProgressKeys := make([]string, 0, 6)
+	for _, hashKey := range []string{hashKeyInProgressEmployees, hashKeyInProgressSleeps,
+		hashKeyEmployeesSearches} {
+		hashKey = c.namespacedKey(hashKey)
+		inProgressKeys = append(inProgressKeys, hashKey, expiryKey(hashKey))
 	}
-	if _, err := c.redisClient.Del(ctx, hashKeyNotFound).Result(); err != nil {
+	if err := c.del(ctx, inProgressKeys...); err != nil {
 		return err
 	}
-	if _, err := c.redisClient.Del(ctx, hashKeyNotFoundMutex).Result(); err != nil {
-		return err
+	if c.config.inProgressEnabled {
+		if err := c.redisClient.Publish(ctx, c.namespacedKey(channelFilled), filledAll).Err(); err != nil {
+			return err
+		}
 	}
-	return nil
+	hashKey := c.namespacedKey(hashKeyNotFound)
+	return c.del(ctx, hashKey, expiryKey(hashKey), c.namespacedKey(hashKeyNotFoundSearches))
 }
 
 func (c *redisCache) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee, error) {
-	key := fmt.Sprint(empNo)
 	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
 	defer cancel()
-	value, err := c.redisClient.HGet(ctx, hashKeyEmployees, key).Result()
+	entry, result, err := c.readOrClaim(ctx, c.key(keyEmployees, empNo),
+		c.namespacedKey(hashKeyInProgressEmployees), c.namespacedKey(hashKeyNotFound), fmt.Sprint(empNo))
 	if err != nil {
-		switch {
-		default:
-			return nil, err
-		case errors.Is(err, redis.Nil):
-			if !c.config.inProgressEnabled {
-				return nil, ErrEmployeeNotCached
-			}
-			c.Lock(hashKeyInProgressEmployeesMutex)
-			defer c.Unlock(hashKeyInProgressEmployeesMutex)
-			tNow := time.Now().UnixNano()
-			result, err := c.redisClient.HSetNX(ctx, hashKeyInProgressEmployees, key,
-				fmt.Sprint(tNow)).Result()
-			if err != nil {
-				return nil, fmt.Errorf("erorr while setting employee (%s) read in progress: %w", key, err)
-			}
-			if !result {
-				return nil, ErrEmployeeReadAlreadySet
-			}
-			return nil, ErrEmployeeReadSet
-		}
+		return nil, fmt.Errorf("error while reading employee (%d): %w", empNo, err)
+	}
+	switch result {
+	case readOrClaimNotCached:
+		return nil, ErrEmployeeNotCached
+	case readOrClaimNotFound:
+		return nil, ErrEmployeeNotFoundCached
+	case readOrClaimGranted:
+		return nil, ErrEmployeeReadSet
+	case readOrClaimHeld:
+		return nil, ErrEmployeeReadAlreadySet
 	}
 	employee := &data.Employee{}
-	if err := employee.UnmarshalBinary([]byte(value)); err != nil {
+	if err := entry.decode(employee); err != nil {
 		return nil, err
 	}
-	return employee, nil
+	return employee, entry.freshness(c.staleTTL(),
+		c.config.xFetchBeta).err(ErrEmployeeStale, ErrEmployeeRefresh)
 }
 
 func (c *redisCache) EmployeesRead(ctx context.Context, search data.EmployeeSearch) ([]*data.Employee, error) {
@@ -431,41 +1070,58 @@ func (c *redisCache) EmployeesRead(ctx context.Context, search data.EmployeeSear
 	if err != nil {
 		return nil, err
 	}
-	value, err := c.redisClient.HGet(ctx, hashKeyEmployeesSearch, searchKey).Result()
+	entry, result, err := c.readOrClaim(ctx, c.key(keyEmployeesSearch, searchKey),
+		c.namespacedKey(hashKeyInProgressEmployees), c.namespacedKey(hashKeyNotFound), searchKey)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("error while reading employee search: %w", err)
 	}
-	if searchKey == "" || value == "" {
-		if !c.config.inProgressEnabled {
-			return nil, ErrEmployeeSearchNotCached
-		}
-		c.Lock(hashKeyInProgressEmployeesMutex)
-		defer c.Unlock(hashKeyInProgressEmployeesMutex)
-		tNow := time.Now().UnixNano()
-		result, err := c.redisClient.HSetNX(ctx, hashKeyInProgressEmployees, searchKey,
-			fmt.Sprint(tNow)).Result()
-		if err != nil {
-			return nil, fmt.Errorf("erorr while setting employee search in progress: %w", err)
+	if result == readOrClaimValue {
+		var employeeSearch storedEmployeeSearch
+		if err := entry.decode(&employeeSearch); err != nil {
+			return nil, err
 		}
-		if !result {
-			return nil, ErrEmployeesSearchAlreadySet
+		f := entry.freshness(c.staleTTL(), c.config.xFetchBeta)
+		employeeKeys := make([]string, 0, len(employeeSearch.EmpNos))
+		for _, empNo := range employeeSearch.EmpNos {
+			employeeKeys = append(employeeKeys, c.key(keyEmployees, empNo))
 		}
-		return nil, ErrEmployeesSearchSet
-	}
-	empNos := strings.Split(value, ",")
-	employees := make([]*data.Employee, 0, len(empNos))
-	for _, empNo := range empNos {
-		value, err := c.redisClient.HGet(ctx, hashKeyEmployees, fmt.Sprint(empNo)).Result()
+		entries, err := c.getEntries(ctx, employeeKeys...)
 		if err != nil {
 			return nil, err
 		}
-		employee := &data.Employee{}
-		if err := employee.UnmarshalBinary([]byte(value)); err != nil {
-			return nil, err
+		employees := make([]*data.Employee, 0, len(entries))
+		for _, entry := range entries {
+			if entry == nil {
+				//KIM: an employee can expire before its search, in which
+				// case the search is incomplete and can't be served
+				employees = nil
+				_, _ = c.redisClient.Del(ctx, c.key(keyEmployeesSearch, searchKey)).Result()
+				break
+			}
+			employee := &data.Employee{}
+			if err := entry.decode(employee); err != nil {
+				return nil, err
+			}
+			employees = append(employees, employee)
+			f = max(f, entry.freshness(c.staleTTL(), c.config.xFetchBeta))
+		}
+		if employees != nil {
+			return employees, f.err(ErrEmployeeSearchStale, ErrEmployeeSearchRefresh)
+		}
+		//KIM: the incomplete search was deleted, so its fill is claimed
+		if result, err = c.claim(ctx, c.namespacedKey(hashKeyInProgressEmployees), searchKey); err != nil {
+			return nil, fmt.Errorf("erorr while setting employee search in progress: %w", err)
 		}
-		employees = append(employees, employee)
 	}
-	return employees, nil
+	switch result {
+	case readOrClaimNotFound:
+		return nil, ErrEmployeeNotFoundCached
+	case readOrClaimGranted:
+		return nil, ErrEmployeesSearchSet
+	case readOrClaimHeld:
+		return nil, ErrEmployeesSearchAlreadySet
+	}
+	return nil, ErrEmployeeSearchNotCached
 }
 
 func (c *redisCache) EmployeesWrite(ctx context.Context, search data.EmployeeSearch, employees ...*data.Employee) error {
@@ -475,51 +1131,99 @@ func (c *redisCache) EmployeesWrite(ctx context.Context, search data.EmployeeSea
 	if err != nil {
 		return ErrSearchKey(err)
 	}
-	empNos := make([]string, 0, len(employees))
+	empNos := make([]int64, 0, len(employees))
+	fieldsToDelete := make([]string, 0, len(employees)+1)
 	for _, employee := range employees {
-		bytes, err := employee.MarshalBinary()
+		fieldsToDelete = append(fieldsToDelete, fmt.Sprint(employee.EmpNo))
+	}
+	//KIM: writes that don't carry a lease (e.g. refreshes) are accepted,
+	// writes that do must still hold it
+	if token, ok := LeaseFromCtx(ctx); ok && c.config.inProgressEnabled {
+		held, err := c.leaseHeld(ctx, c.namespacedKey(hashKeyInProgressEmployees), token,
+			append(fieldsToDelete, searchKey)...)
 		if err != nil {
 			return err
 		}
-		if _, err := c.redisClient.HSet(ctx, hashKeyEmployees,
-			fmt.Sprint(employee.EmpNo), string(bytes)).Result(); err != nil {
-			return err
+		if !held {
+			return ErrLeaseInvalid
 		}
-		empNos = append(empNos, fmt.Sprint(employee.EmpNo))
 	}
-	if _, err := c.redisClient.HSet(ctx, hashKeyEmployeesSearch, searchKey,
-		strings.Join(empNos, ",")).Result(); err != nil {
+	entries := make([]redisEntry, 0, len(employees)+1)
+	employeeKeys := make([]string, 0, len(employees))
+	for _, employee := range employees {
+		key := c.key(keyEmployees, employee.EmpNo)
+		entries = append(entries, redisEntry{key: key, value: employee})
+		employeeKeys = append(employeeKeys, key)
+		empNos = append(empNos, employee.EmpNo)
+	}
+	//KIM: the employees and the search are written together so the search
+	// is never visible before its employees, the search depends on its
+	// employees so it's rejected if any of them were invalidated after the
+	// fill began
+	entries = append(entries, redisEntry{
+		key: c.key(keyEmployeesSearch, searchKey),
+		value: &storedEmployeeSearch{
+			Search: search,
+			EmpNos: empNos,
+		},
+		dependencies: employeeKeys,
+	})
+	if err := c.setEntries(ctx, entries...); err != nil {
 		return err
 	}
+	if err := c.indexEmployeesSearch(ctx, searchKey, empNos...); err != nil {
+		return err
+	}
+	if err := c.registerEmployeesSearch(ctx, searchKey, search); err != nil {
+		return err
+	}
+	if c.config.notFoundEnabled {
+		if err := c.deleteMarkers(ctx, c.namespacedKey(hashKeyNotFound),
+			append(fieldsToDelete, searchKey)...); err != nil {
+			return err
+		}
+		if err := c.redisClient.HDel(ctx, c.namespacedKey(hashKeyNotFoundSearches), searchKey).Err(); err != nil {
+			return err
+		}
+	}
 	if c.config.inProgressEnabled {
-		c.Lock(hashKeyInProgressEmployeesMutex)
-		defer c.Unlock(hashKeyInProgressEmployeesMutex)
-
-		fieldsToDelete := append(empNos, searchKey)
-		_, _ = c.redisClient.HDel(ctx, hashKeyInProgressEmployees, fieldsToDelete...).Result()
+		_ = c.deleteMarkers(ctx, c.namespacedKey(hashKeyInProgressEmployees),
+			append(fieldsToDelete, searchKey)...)
+		c.filled(ctx, entryTypeEmployee, fieldsToDelete...)
+		c.filled(ctx, entryTypeEmployeeSearch, searchKey)
 	}
 	return nil
 }
 
 func (c *redisCache) EmployeesDelete(ctx context.Context, e ...int64) error {
-	var empNos []string
+	var empNos, keys []string
 
 	if len(e) <= 0 {
 		return nil
 	}
 	for _, empNo := range e {
 		empNos = append(empNos, fmt.Sprint(empNo))
+		keys = append(keys, c.key(keyEmployees, empNo))
 	}
-	if _, err := c.redisClient.HDel(ctx, hashKeyEmployees,
-		empNos...).Result(); err != nil {
+	//KIM: the invalidation is recorded before the keys are deleted so
+	// that a fill can't write a key between its deletion and the record
+	if err := c.invalidate(ctx, keys...); err != nil {
+		return err
+	}
+	if err := c.del(ctx, keys...); err != nil {
+		return err
+	}
+	if err := c.deleteEmployeesSearches(ctx, e...); err != nil {
 		return err
 	}
 	if c.config.inProgressEnabled {
-		c.Lock(hashKeyInProgressEmployeesMutex)
-		defer c.Unlock(hashKeyInProgressEmployeesMutex)
-
-		_, _ = c.redisClient.HDel(ctx, hashKeyEmployees,
-			empNos...).Result()
+		_ = c.deleteMarkers(ctx, c.namespacedKey(hashKeyInProgressEmployees), empNos...)
+		c.filled(ctx, entryTypeEmployee, empNos...)
+	}
+	if c.config.notFoundEnabled {
+		if err := c.deleteMarkers(ctx, c.namespacedKey(hashKeyNotFound), empNos...); err != nil {
+			return err
+		}
 	}
 	return nil
 }
@@ -532,87 +1236,297 @@ func (c *redisCache) EmployeesNotFoundWrite(ctx context.Context, search data.Emp
 	if err != nil {
 		return ErrSearchKey(err)
 	}
-	c.Lock(hashKeyNotFoundMutex)
-	defer c.Unlock(hashKeyNotFoundMutex)
-	tNow := time.Now().UnixNano()
-	if _, err := c.redisClient.HSetNX(ctx, hashKeyNotFound, searchKey,
-		fmt.Sprint(tNow)).Result(); err != nil {
+	fields := []string{searchKey}
+	for _, empNo := range empNos {
+		fields = append(fields, fmt.Sprint(empNo))
+	}
+	if err := c.setMarkers(ctx, c.namespacedKey(hashKeyNotFound), time.Now(), fields...); err != nil {
 		return fmt.Errorf("erorr while setting employee search not found: %w", err)
 	}
-	for _, empNo := range empNos {
-		if _, err := c.redisClient.HSetNX(ctx, hashKeyNotFound, fmt.Sprint(empNo),
-			fmt.Sprint(tNow)).Result(); err != nil {
-			return fmt.Errorf("erorr while setting employee not found: %w", err)
-		}
+	bytes, err := search.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	if _, err := c.redisClient.HSet(ctx, c.namespacedKey(hashKeyNotFoundSearches), searchKey,
+		bytes).Result(); err != nil {
+		return fmt.Errorf("erorr while setting employee search not found: %w", err)
+	}
+	//KIM: a fill that found nothing is complete, so anyone waiting on it
+	// can read the not found entry
+	if c.config.inProgressEnabled {
+		_ = c.deleteMarkers(ctx, c.namespacedKey(hashKeyInProgressEmployees), fields...)
+		c.filled(ctx, entryTypeEmployeeSearch, searchKey)
+		c.filled(ctx, entryTypeEmployee, fields[1:]...)
 	}
 	return nil
 }
 
-func (c *redisCache) SleepRead(ctx context.Context, sleepId string) (*data.Sleep, error) {
+func (c *redisCache) EmployeeSearchesRead(ctx context.Context) (map[string]data.EmployeeSearch, error) {
 	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
 	defer cancel()
-	value, err := c.redisClient.HGet(ctx, hashKeySleep, sleepId).Result()
-	if err != nil {
-		switch {
-		default:
+	searches := make(map[string]data.EmployeeSearch)
+	//KIM: searches that have expired are pruned from the registry before
+	// it's read, a search that expires after it's read is harmless since
+	// deleting it is a no-op
+	hashKey := c.namespacedKey(hashKeyEmployeesSearches)
+	var values *redis.MapStringStringCmd
+	if _, err := c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		if expiration := c.maxExpiration(); expiration > 0 {
+			pipe.Eval(ctx, scriptPruneMarkers, []string{hashKey, expiryKey(hashKey)},
+				time.Now().Add(-expiration).UnixMilli())
+		}
+		values = pipe.HGetAll(ctx, hashKey)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for searchKey, value := range values.Val() {
+		var search data.EmployeeSearch
+		if err := search.UnmarshalBinary([]byte(value)); err != nil {
 			return nil, err
-		case errors.Is(err, redis.Nil):
-			if !c.config.inProgressEnabled {
-				return nil, ErrSleepNotCached
-			}
-			c.Lock(hashKeyInProgressSleepsMutex)
-			defer c.Unlock(hashKeyInProgressSleepsMutex)
-			tNow := time.Now().UnixNano()
-			result, err := c.redisClient.HSetNX(ctx, hashKeyInProgressSleeps, sleepId,
-				fmt.Sprint(tNow)).Result()
-			if err != nil {
-				return nil, fmt.Errorf("erorr while setting sleep (%s) read in progress: %w", sleepId, err)
-			}
-			if !result {
-				return nil, ErrSleepReadAlreadySet
+		}
+		searches[searchKey] = search
+	}
+	if c.config.notFoundEnabled {
+		values, err := c.redisClient.HGetAll(ctx, c.namespacedKey(hashKeyNotFoundSearches)).Result()
+		if err != nil {
+			return nil, err
+		}
+		for searchKey, value := range values {
+			var search data.EmployeeSearch
+			if err := search.UnmarshalBinary([]byte(value)); err != nil {
+				return nil, err
 			}
-			return nil, ErrSleepReadSet
+			searches[searchKey] = search
 		}
 	}
+	return searches, nil
+}
+
+func (c *redisCache) EmployeeSearchesDelete(ctx context.Context, searchKeys ...string) error {
+	var keys []string
+
+	if len(searchKeys) <= 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
+	defer cancel()
+	for _, searchKey := range searchKeys {
+		keys = append(keys, c.key(keyEmployeesSearch, searchKey))
+	}
+	if err := c.invalidate(ctx, keys...); err != nil {
+		return err
+	}
+	if err := c.del(ctx, keys...); err != nil {
+		return err
+	}
+	if err := c.deleteMarkers(ctx, c.namespacedKey(hashKeyEmployeesSearches), searchKeys...); err != nil {
+		return err
+	}
+	if c.config.inProgressEnabled {
+		_ = c.deleteMarkers(ctx, c.namespacedKey(hashKeyInProgressEmployees), searchKeys...)
+		c.filled(ctx, entryTypeEmployeeSearch, searchKeys...)
+	}
+	if c.config.notFoundEnabled {
+		_ = c.deleteMarkers(ctx, c.namespacedKey(hashKeyNotFound), searchKeys...)
+		_, _ = c.redisClient.HDel(ctx, c.namespacedKey(hashKeyNotFoundSearches), searchKeys...).Result()
+	}
+	return nil
+}
+
+func (c *redisCache) SleepRead(ctx context.Context, sleepId string) (*data.Sleep, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
+	defer cancel()
+	entry, result, err := c.readOrClaim(ctx, c.key(keySleep, sleepId),
+		c.namespacedKey(hashKeyInProgressSleeps), "", sleepId)
+	if err != nil {
+		return nil, fmt.Errorf("error while reading sleep (%s): %w", sleepId, err)
+	}
+	switch result {
+	case readOrClaimNotCached:
+		return nil, ErrSleepNotCached
+	case readOrClaimGranted:
+		return nil, ErrSleepReadSet
+	case readOrClaimHeld:
+		return nil, ErrSleepReadAlreadySet
+	}
 	sleep := &data.Sleep{}
-	if err := sleep.UnmarshalBinary([]byte(value)); err != nil {
+	if err := entry.decode(sleep); err != nil {
 		return nil, err
 	}
-	return sleep, nil
+	return sleep, entry.freshness(c.staleTTL(),
+		c.config.xFetchBeta).err(ErrSleepStale, ErrSleepRefresh)
 }
 
 func (c *redisCache) SleepWrite(ctx context.Context, sleep *data.Sleep) error {
 	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
 	defer cancel()
-	bytes, err := sleep.MarshalBinary()
-	if err != nil {
-		return err
+	if token, ok := LeaseFromCtx(ctx); ok && c.config.inProgressEnabled {
+		held, err := c.leaseHeld(ctx, c.namespacedKey(hashKeyInProgressSleeps), token, sleep.Id)
+		if err != nil {
+			return err
+		}
+		if !held {
+			return ErrLeaseInvalid
+		}
 	}
-	if _, err := c.redisClient.HSet(ctx, hashKeySleep,
-		sleep.Id, string(bytes)).Result(); err != nil {
+	if err := c.set(ctx, c.key(keySleep, sleep.Id), sleep); err != nil {
 		return err
 	}
 	if c.config.inProgressEnabled {
-		c.Lock(hashKeyInProgressSleepsMutex)
-		defer c.Unlock(hashKeyInProgressSleepsMutex)
-		_, _ = c.redisClient.HDel(ctx, hashKeyInProgressSleeps, sleep.Id).Result()
+		_ = c.deleteMarkers(ctx, c.namespacedKey(hashKeyInProgressSleeps), sleep.Id)
+		c.filled(ctx, entryTypeSleep, sleep.Id)
 	}
 	return nil
 }
 
 func (c *redisCache) SleepsDelete(ctx context.Context, sleepIds ...string) error {
+	var keys []string
+
 	if len(sleepIds) <= 0 {
 		return nil
 	}
-	if _, err := c.redisClient.HDel(ctx, hashKeyEmployees,
-		sleepIds...).Result(); err != nil {
+	for _, sleepId := range sleepIds {
+		keys = append(keys, c.key(keySleep, sleepId))
+	}
+	if err := c.invalidate(ctx, keys...); err != nil {
+		return err
+	}
+	if err := c.del(ctx, keys...); err != nil {
 		return err
 	}
 	if c.config.inProgressEnabled {
-		c.Lock(hashKeyInProgressEmployeesMutex)
-		defer c.Unlock(hashKeyInProgressEmployeesMutex)
-		_, _ = c.redisClient.HDel(ctx, hashKeyEmployees,
-			sleepIds...).Result()
+		_ = c.deleteMarkers(ctx, c.namespacedKey(hashKeyInProgressSleeps), sleepIds...)
+		c.filled(ctx, entryTypeSleep, sleepIds...)
 	}
 	return nil
 }
+
+// entryTypeKeyPrefix returns the key prefix entries of the given
+// entry type are stored with
+func entryTypeKeyPrefix(entryType string) (string, error) {
+	switch entryType {
+	default:
+		return "", ErrCacheEntryTypeUnsupported
+	case entryTypeEmployee:
+		return keyEmployees, nil
+	case entryTypeEmployeeSearch:
+		return keyEmployeesSearch, nil
+	case entryTypeSleep:
+		return keySleep, nil
+	}
+}
+
+func (c *redisCache) Generation(ctx context.Context) (int64, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
+	defer cancel()
+	generation, err := c.redisClient.Get(ctx, c.namespacedKey(keyGeneration)).Int64()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return 0, err
+	}
+	return generation, nil
+}
+
+func (c *redisCache) FillWait(ctx context.Context, entryType, key string) error {
+	var hashKey string
+
+	if !c.config.inProgressEnabled {
+		return ErrWaitUnsupported
+	}
+	switch entryType {
+	default:
+		return ErrCacheEntryTypeUnsupported
+	case entryTypeEmployee, entryTypeEmployeeSearch:
+		hashKey = c.namespacedKey(hashKeyInProgressEmployees)
+	case entryTypeSleep:
+		hashKey = c.namespacedKey(hashKeyInProgressSleeps)
+	}
+	//KIM: the waiter is added before the lease is read, so the fill
+	// can't be published between the read and the wait
+	waiter, remove := c.waiters.add(entryType, key)
+	value, err := c.redisClient.HGet(ctx, hashKey, key).Result()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		remove()
+		return err
+	}
+	if err != nil || parseLease(value).expired(c.config.inProgressTTL) {
+		remove()
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		remove()
+		return ctx.Err()
+	case <-waiter:
+		return nil
+	}
+}
+
+func (c *redisCache) KeysRead(ctx context.Context, entryType string) ([]data.CacheKey, error) {
+	var keys []data.CacheKey
+
+	types, err := entryTypes(entryType)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
+	defer cancel()
+	for _, entryType := range types {
+		prefix, err := entryTypeKeyPrefix(entryType)
+		if err != nil {
+			return nil, err
+		}
+		redisKeys, err := c.scan(ctx, c.namespacedKey(prefix)+":*")
+		if err != nil {
+			return nil, err
+		}
+		for _, redisKey := range redisKeys {
+			//KIM: the entry may have expired since it was scanned
+			entry, err := c.getEntry(ctx, redisKey)
+			if err != nil {
+				if errors.Is(err, redis.Nil) {
+					continue
+				}
+				return nil, err
+			}
+			keys = append(keys, newCacheKey(entryType,
+				c.keyId(prefix, redisKey), entry.entryExpiry, c.staleTTL()))
+		}
+	}
+	return keys, nil
+}
+
+func (c *redisCache) EntryRead(ctx context.Context, entryType, key string) (*data.CacheEntry, error) {
+	prefix, err := entryTypeKeyPrefix(entryType)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.config.timeout)
+	defer cancel()
+	entry, err := c.getEntry(ctx, c.key(prefix, key))
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, ErrCacheEntryNotFound
+		}
+		return nil, err
+	}
+	//KIM: the value is decoded and re-encoded as json since it
+	// may have been encoded with a codec that isn't json
+	value := newEntryValue(entryType)
+	if err := entry.decode(value); err != nil {
+		return nil, err
+	}
+	bytes, err := json.Marshal(value)
+	if err != nil {
+		return nil, err
+	}
+	return &data.CacheEntry{
+		CacheKey: newCacheKey(entryType, key, entry.entryExpiry, c.staleTTL()),
+		Format:   entry.format.String(),
+		Value:    bytes,
+	}, nil
+}
+
+func (c *redisCache) EntryDelete(ctx context.Context, entryType, key string) error {
+	return entryDelete(ctx, c, entryType, key)
+}
@@ -0,0 +1,108 @@
+package cache
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/antonio-alexander/go-blog-cache/internal/data"
+)
+
+// snapshotVersion is the version of the snapshot format, it should
+// be incremented any time the format changes; snapshots with a
+// different version are rejected
+const snapshotVersion int = 1
+
+type snapshotExpiry struct {
+	CachedAt int64         `json:"cached_at"`
+	TTL      time.Duration `json:"ttl"`
+	Delta    time.Duration `json:"delta,omitempty"`
+}
+
+func newSnapshotExpiry(e entryExpiry) snapshotExpiry {
+	return snapshotExpiry{
+		CachedAt: e.cachedAt,
+		TTL:      e.ttl,
+		Delta:    e.delta,
+	}
+}
+
+func (e snapshotExpiry) expiry() entryExpiry {
+	return entryExpiry{
+		cachedAt: e.CachedAt,
+		ttl:      e.TTL,
+		delta:    e.Delta,
+	}
+}
+
+type snapshotEmployee struct {
+	Employee *data.Employee `json:"employee"`
+	snapshotExpiry
+}
+
+type snapshotEmployeeSearch struct {
+	SearchKey string              `json:"search_key"`
+	Search    data.EmployeeSearch `json:"search"`
+	EmpNos    []int64             `json:"emp_nos"`
+	snapshotExpiry
+}
+
+type snapshotSleep struct {
+	Sleep *data.Sleep `json:"sleep"`
+	snapshotExpiry
+}
+
+// memorySnapshot is the (serialized) contents of a memory cache
+type memorySnapshot struct {
+	Version          int                      `json:"version"`
+	CreatedAt        int64                    `json:"created_at"`
+	Employees        []snapshotEmployee       `json:"employees"`
+	EmployeeSearches []snapshotEmployeeSearch `json:"employee_searches"`
+	Sleeps           []snapshotSleep          `json:"sleeps"`
+}
+
+// writeSnapshot will write the snapshot to the file; the snapshot is
+// written to a temporary file first so an existing snapshot isn't
+// corrupted if the write fails
+func writeSnapshot(file string, snapshot *memorySnapshot) error {
+	bytes, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	tmpFile, err := os.CreateTemp(filepath.Dir(file), filepath.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmpFile.Name())
+	if _, err := tmpFile.Write(bytes); err != nil {
+		_ = tmpFile.Close()
+		return err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFile.Name(), file)
+}
+
+// readSnapshot will read the snapshot from the file, if the file doesn't
+// exist, no snapshot (and no error) is returned
+func readSnapshot(file string) (*memorySnapshot, error) {
+	bytes, err := os.ReadFile(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	snapshot := &memorySnapshot{}
+	if err := json.Unmarshal(bytes, snapshot); err != nil {
+		return nil, err
+	}
+	if snapshot.Version != snapshotVersion {
+		return nil, fmt.Errorf("snapshot version (%d) not supported, expected %d",
+			snapshot.Version, snapshotVersion)
+	}
+	return snapshot, nil
+}
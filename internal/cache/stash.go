@@ -2,8 +2,12 @@ package cache
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/antonio-alexander/go-blog-cache/internal"
 	"github.com/antonio-alexander/go-blog-cache/internal/data"
@@ -12,9 +16,83 @@ import (
 	"github.com/antonio-alexander/go-stash"
 )
 
+// stashSearchIndex is the set of search keys that reference an employee
+type stashSearchIndex map[string]struct{}
+
+func (s *stashSearchIndex) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *stashSearchIndex) UnmarshalBinary(bytes []byte) error {
+	return json.Unmarshal(bytes, s)
+}
+
+// stashSearches is the criteria of each cached search by search key
+type stashSearches map[string]data.EmployeeSearch
+
+func (s *stashSearches) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *stashSearches) UnmarshalBinary(bytes []byte) error {
+	return json.Unmarshal(bytes, s)
+}
+
+// stashEmployees is when each cached employee was cached by emp_no
+type stashEmployees map[int64]int64
+
+func (s *stashEmployees) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *stashEmployees) UnmarshalBinary(bytes []byte) error {
+	return json.Unmarshal(bytes, s)
+}
+
+// stashSleeps is when each cached sleep was cached by sleep id
+type stashSleeps map[string]int64
+
+func (s *stashSleeps) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *stashSleeps) UnmarshalBinary(bytes []byte) error {
+	return json.Unmarshal(bytes, s)
+}
+
+// stashEmployeeSearch is a cached search, the criteria of the search
+// and the emp_nos of the employees it found
+type stashEmployeeSearch struct {
+	Search data.EmployeeSearch `json:"search"`
+	EmpNos []int64             `json:"emp_nos"`
+}
+
+func (s *stashEmployeeSearch) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *stashEmployeeSearch) UnmarshalBinary(bytes []byte) error {
+	return json.Unmarshal(bytes, s)
+}
+
+const (
+	stashKeySearches  string = "employees_searches"
+	stashKeyEmployees string = "employees_cached"
+	stashKeySleeps    string = "sleeps_cached"
+)
+
 type stashCache struct {
-	logger utilities.Logger
-	stash  interface {
+	sync.Mutex //protects the search index and registries
+	config     struct {
+		inProgressEnabled bool
+		inProgressTTL     time.Duration
+		notFoundEnabled   bool
+		notFoundTTL       time.Duration
+		hashKey           string
+	}
+	logger  utilities.Logger
+	markers stashMarkerStore
+	stash   interface {
 		stash.Configurer
 		stash.Parameterizer
 		stash.Initializer
@@ -28,6 +106,7 @@ func NewStash(parameters ...any) interface {
 	internal.Opener
 	internal.Clearer
 	Cache
+	Inspector
 } {
 	c := &stashCache{}
 	for _, p := range parameters {
@@ -63,7 +142,151 @@ func (c *stashCache) Trace(ctx context.Context, format string, v ...any) {
 	}
 }
 
+// employeeKey namespaces the employee's entry so an emp_no, search key
+// or sleep id can't collide with each other or with the registries
+// (which aren't namespaced)
+func (c *stashCache) employeeKey(empNo int64) string {
+	return fmt.Sprintf("employee:%d", empNo)
+}
+
+func (c *stashCache) employeeSearchKey(searchKey string) string {
+	return "employee_search:" + searchKey
+}
+
+func (c *stashCache) sleepKey(sleepId string) string {
+	return "sleep:" + sleepId
+}
+
+func (c *stashCache) searchIndexKey(empNo int64) string {
+	return fmt.Sprintf("employees_search_index:%d", empNo)
+}
+
+// indexEmployeeSearch will add the search key to the employee's search index
+func (c *stashCache) indexEmployeeSearch(empNo int64, searchKey string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	searchIndex := make(stashSearchIndex)
+	_ = c.Stasher.Read(c.searchIndexKey(empNo), &searchIndex)
+	searchIndex[searchKey] = struct{}{}
+	_, err := c.Stasher.Write(c.searchIndexKey(empNo), &searchIndex)
+	return err
+}
+
+// deleteEmployeeSearchIndex will remove the employee's search index and
+// return the search keys it contained
+func (c *stashCache) deleteEmployeeSearchIndex(empNo int64) []string {
+	c.Lock()
+	defer c.Unlock()
+
+	searchIndex := make(stashSearchIndex)
+	if err := c.Stasher.Read(c.searchIndexKey(empNo), &searchIndex); err != nil {
+		return nil
+	}
+	_ = c.Stasher.Delete(c.searchIndexKey(empNo))
+	searchKeys := make([]string, 0, len(searchIndex))
+	for searchKey := range searchIndex {
+		searchKeys = append(searchKeys, searchKey)
+	}
+	return searchKeys
+}
+
+// writeSearch will add the search (criteria) to the cached searches
+func (c *stashCache) writeSearch(searchKey string, search data.EmployeeSearch) error {
+	c.Lock()
+	defer c.Unlock()
+
+	searches := make(stashSearches)
+	_ = c.Stasher.Read(stashKeySearches, &searches)
+	searches[searchKey] = search
+	_, err := c.Stasher.Write(stashKeySearches, &searches)
+	return err
+}
+
+// writeEmployees will add (or remove) the employees to (or from) the
+// cached employees
+func (c *stashCache) writeEmployees(cached bool, empNos ...int64) error {
+	c.Lock()
+	defer c.Unlock()
+
+	tNow := time.Now().UnixNano()
+	employees := make(stashEmployees)
+	_ = c.Stasher.Read(stashKeyEmployees, &employees)
+	for _, empNo := range empNos {
+		switch {
+		case cached:
+			employees[empNo] = tNow
+		default:
+			delete(employees, empNo)
+		}
+	}
+	_, err := c.Stasher.Write(stashKeyEmployees, &employees)
+	return err
+}
+
+// writeSleeps will add (or remove) the sleeps to (or from) the cached
+// sleeps
+func (c *stashCache) writeSleeps(cached bool, sleepIds ...string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	tNow := time.Now().UnixNano()
+	sleeps := make(stashSleeps)
+	_ = c.Stasher.Read(stashKeySleeps, &sleeps)
+	for _, sleepId := range sleepIds {
+		switch {
+		case cached:
+			sleeps[sleepId] = tNow
+		default:
+			delete(sleeps, sleepId)
+		}
+	}
+	_, err := c.Stasher.Write(stashKeySleeps, &sleeps)
+	return err
+}
+
+// deleteMarkers will remove the in progress leases and not found markers
+func (c *stashCache) deleteMarkers(ctx context.Context, markers stashMarkers) error {
+	if c.config.inProgressEnabled {
+		if err := c.markers.deleteInProgress(ctx, markers); err != nil {
+			return err
+		}
+	}
+	if c.config.notFoundEnabled {
+		return c.markers.deleteNotFound(ctx, markers)
+	}
+	return nil
+}
+
 func (c *stashCache) Configure(envs map[string]string) error {
+	if s, ok := envs["CACHE_SET_READ_TTL"]; ok {
+		inProgressTTL, _ := strconv.Atoi(s)
+		c.config.inProgressTTL = time.Second * time.Duration(inProgressTTL)
+	}
+	if inProgressEnabled, ok := envs["CACHE_ENABLE_IN_PROGRESS"]; ok {
+		c.config.inProgressEnabled, _ = strconv.ParseBool(inProgressEnabled)
+	}
+	if s, ok := envs["CACHE_NOT_FOUND_TTL"]; ok {
+		notFoundTTL, _ := strconv.Atoi(s)
+		c.config.notFoundTTL = time.Second * time.Duration(notFoundTTL)
+	}
+	if notFoundEnabled, ok := envs["CACHE_NOT_FOUND_ENABLED"]; ok {
+		c.config.notFoundEnabled, _ = strconv.ParseBool(notFoundEnabled)
+	}
+	c.config.hashKey = stashDefaultHashKey
+	if s := envs["REDIS_HASH_KEY"]; s != "" {
+		c.config.hashKey = s
+	}
+	//KIM: go-stash's redis stash creates its own client with only the
+	// address, password and database, rather than silently connecting
+	// without tls or as the default user, those settings are rejected
+	if _, ok := c.stash.(stashRedisClient); ok {
+		auth := redisAuth{}
+		auth.configure(envs)
+		if auth.username != "" || auth.tlsEnabled {
+			return errors.New("stash (redis) doesn't support redis tls or acl authentication")
+		}
+	}
 	if c.stash != nil {
 		if err := c.stash.Configure(envs); err != nil {
 			return err
@@ -74,7 +297,27 @@ func (c *stashCache) Configure(envs map[string]string) error {
 
 func (c *stashCache) Open(ctx context.Context) error {
 	if c.stash != nil {
-		return c.stash.Initialize()
+		if err := c.stash.Initialize(); err != nil {
+			return err
+		}
+	}
+	//KIM: the markers of a stash that's shared (e.g. redis) are stored
+	// next to it so every process sees the same leases, the client is
+	// only available once the stash is initialized
+	switch client, ok := c.stash.(stashRedisClient); {
+	default:
+		c.markers = &stashLocalMarkers{
+			Stasher:       c.Stasher,
+			inProgressTTL: c.config.inProgressTTL,
+			notFoundTTL:   c.config.notFoundTTL,
+		}
+	case ok:
+		c.markers = &stashRedisMarkers{
+			client:        client,
+			prefix:        c.config.hashKey + ":",
+			inProgressTTL: c.config.inProgressTTL,
+			notFoundTTL:   c.config.notFoundTTL,
+		}
 	}
 	return nil
 }
@@ -87,89 +330,403 @@ func (c *stashCache) Close(ctx context.Context) error {
 }
 
 func (c *stashCache) Clear(ctx context.Context) error {
-	return c.Stasher.Clear()
+	if err := c.Stasher.Clear(); err != nil {
+		return err
+	}
+	return c.markers.clear(ctx)
 }
 
 func (c *stashCache) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee, error) {
 	employee := &data.Employee{}
-	if err := c.Stasher.Read(fmt.Sprint(empNo), employee); err != nil {
-		return nil, ErrEmployeeNotCached
+	if err := c.Stasher.Read(c.employeeKey(empNo), employee); err == nil {
+		return employee, nil
+	}
+	if c.config.notFoundEnabled {
+		notFound, err := c.markers.notFound(ctx, entryTypeEmployee, strconv.FormatInt(empNo, 10))
+		if err != nil {
+			return nil, err
+		}
+		if notFound {
+			return nil, ErrEmployeeNotFoundCached
+		}
 	}
-	return employee, nil
+	if c.config.inProgressEnabled {
+		token, ok, err := c.markers.grantLease(ctx, entryTypeEmployee, strconv.FormatInt(empNo, 10))
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrEmployeeReadAlreadySet
+		}
+		setLeaseCtx(ctx, token)
+		return nil, ErrEmployeeReadSet
+	}
+	return nil, ErrEmployeeNotCached
 }
 
 func (c *stashCache) EmployeesRead(ctx context.Context, search data.EmployeeSearch) ([]*data.Employee, error) {
-	//REVIEW: I don't think this is complete
 	searchKey, err := search.ToKey()
 	if err != nil {
-		return nil, err
+		return nil, ErrSearchKey(err)
 	}
-	//REVIEW: should we pull the data out?
-	if err := c.Stasher.Read(searchKey, &search); err != nil {
-		return nil, ErrEmployeeSearchNotCached
-	}
-	employees := make([]*data.Employee, 0, len(search.EmpNos))
-	for _, empNo := range search.EmpNos {
-		employee := &data.Employee{}
-		if err := c.Stasher.Read(fmt.Sprint(empNo), employee); err != nil {
-			//KIM: we don't want to fail half way, so any failure here
-			// should return an error
-			if err := c.Stasher.Delete(searchKey); err != nil {
-				c.Error(ctx, "error while deleting searchkey (%s): %s",
-					searchKey, err)
+	employeeSearch := &stashEmployeeSearch{}
+	if err := c.Stasher.Read(c.employeeSearchKey(searchKey), employeeSearch); err == nil {
+		employees := make([]*data.Employee, 0, len(employeeSearch.EmpNos))
+		for _, empNo := range employeeSearch.EmpNos {
+			employee := &data.Employee{}
+			if err := c.Stasher.Read(c.employeeKey(empNo), employee); err != nil {
+				//KIM: employees are evicted independently of their searches,
+				// a search can't be served if any of its employees were
+				if err := c.Stasher.Delete(c.employeeSearchKey(searchKey)); err != nil {
+					c.Error(ctx, "error while deleting searchkey (%s): %s",
+						searchKey, err)
+				}
+				employees = nil
+				break
 			}
+			employees = append(employees, employee)
+		}
+		if employees != nil {
+			return employees, nil
+		}
+	}
+	if c.config.notFoundEnabled {
+		notFound, err := c.markers.notFound(ctx, entryTypeEmployeeSearch, searchKey)
+		if err != nil {
+			return nil, err
+		}
+		if notFound {
 			return nil, ErrEmployeeNotFoundCached
 		}
-		employees = append(employees, employee)
 	}
-	return employees, nil
+	if c.config.inProgressEnabled {
+		token, ok, err := c.markers.grantLease(ctx, entryTypeEmployeeSearch, searchKey)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrEmployeesSearchAlreadySet
+		}
+		setLeaseCtx(ctx, token)
+		return nil, ErrEmployeesSearchSet
+	}
+	return nil, ErrEmployeeSearchNotCached
 }
 
 func (c *stashCache) EmployeesWrite(ctx context.Context, search data.EmployeeSearch, employees ...*data.Employee) error {
 	searchKey, err := search.ToKey()
 	if err != nil {
-		return err
+		return ErrSearchKey(err)
+	}
+	empNos := make([]int64, 0, len(employees))
+	for _, employee := range employees {
+		empNos = append(empNos, employee.EmpNo)
 	}
-	if _, err := c.Stasher.Write(searchKey, &search); err != nil {
+	markers := stashMarkers{
+		empNos:     empNos,
+		searchKeys: []string{searchKey},
+	}
+	if token, ok := LeaseFromCtx(ctx); ok && c.config.inProgressEnabled {
+		//KIM: writes that don't carry a lease (e.g. refreshes) are
+		// accepted, writes that do must still hold it
+		leaseHeld, err := c.markers.leaseHeld(ctx, token, markers)
+		if err != nil {
+			return err
+		}
+		if !leaseHeld {
+			return ErrLeaseInvalid
+		}
+	}
+	if _, err := c.Stasher.Write(c.employeeSearchKey(searchKey), &stashEmployeeSearch{
+		Search: search,
+		EmpNos: empNos,
+	}); err != nil {
 		return err
 	}
+	if err := c.writeSearch(searchKey, search); err != nil {
+		c.Error(ctx, "error while writing employee search (%s): %s", searchKey, err)
+	}
+	for _, employee := range employees {
+		if err := c.indexEmployeeSearch(employee.EmpNo, searchKey); err != nil {
+			c.Error(ctx, "error while indexing employee (%d) search: %s", employee.EmpNo, err)
+		}
+	}
 	for _, employee := range employees {
-		if _, err := c.Stasher.Write(fmt.Sprint(employee.EmpNo), employee); err != nil {
+		if _, err := c.Stasher.Write(c.employeeKey(employee.EmpNo), employee); err != nil {
 			// we don't care about the error here, but it does make the caching
 			// incomplete
 			c.Error(ctx, "error while writing employee (%d): %s", employee.EmpNo, err)
 		}
 		c.Trace(ctx, "cached employee: %d", employee.EmpNo)
 	}
+	if err := c.writeEmployees(true, empNos...); err != nil {
+		c.Error(ctx, "error while writing cached employees: %s", err)
+	}
+	if err := c.deleteMarkers(ctx, markers); err != nil {
+		c.Error(ctx, "error while deleting employee markers: %s", err)
+	}
 	return nil
 }
 
 func (c *stashCache) EmployeesDelete(ctx context.Context, empNos ...int64) error {
 	for _, empNo := range empNos {
-		if err := c.Stasher.Delete(fmt.Sprint(empNo)); err != nil {
+		if err := c.Stasher.Delete(c.employeeKey(empNo)); err != nil {
 			c.Error(ctx, "error while deleting employee")
 			continue
 		}
 		c.Trace(ctx, "evicted cached employee: %d", empNo)
 	}
-	//KIM: even though we can't directly invalidate the search key, when you attempt to
-	// use the search key and it's found, but not all the employees are, it's automatically
-	// invalidated
+	if err := c.writeEmployees(false, empNos...); err != nil {
+		c.Error(ctx, "error while writing cached employees: %s", err)
+	}
+	for _, empNo := range empNos {
+		for _, searchKey := range c.deleteEmployeeSearchIndex(empNo) {
+			if err := c.Stasher.Delete(c.employeeSearchKey(searchKey)); err != nil {
+				//KIM: the search may have already been evicted
+				continue
+			}
+			c.Trace(ctx, "invalidated cached employee search: %s", searchKey)
+		}
+	}
+	if err := c.deleteMarkers(ctx, stashMarkers{empNos: empNos}); err != nil {
+		c.Error(ctx, "error while deleting employee markers: %s", err)
+	}
 	return nil
 }
 
 func (c *stashCache) EmployeesNotFoundWrite(ctx context.Context, search data.EmployeeSearch, empNos ...int64) error {
-	return errors.New("not supported")
+	if !c.config.notFoundEnabled {
+		return nil
+	}
+	searchKey, err := search.ToKey()
+	if err != nil {
+		return ErrSearchKey(err)
+	}
+	if err := c.markers.writeNotFound(ctx, searchKey, search, empNos...); err != nil {
+		return err
+	}
+	//KIM: a fill that found nothing is complete
+	if !c.config.inProgressEnabled {
+		return nil
+	}
+	return c.markers.deleteInProgress(ctx, stashMarkers{
+		empNos:     empNos,
+		searchKeys: []string{searchKey},
+	})
+}
+
+func (c *stashCache) EmployeeSearchesRead(ctx context.Context) (map[string]data.EmployeeSearch, error) {
+	c.Lock()
+	searches := make(stashSearches)
+	_ = c.Stasher.Read(stashKeySearches, &searches)
+	c.Unlock()
+	if c.config.notFoundEnabled {
+		notFoundSearches, err := c.markers.notFoundSearches(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for searchKey, search := range notFoundSearches {
+			searches[searchKey] = search
+		}
+	}
+	return searches, nil
+}
+
+func (c *stashCache) EmployeeSearchesDelete(ctx context.Context, searchKeys ...string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	searches := make(stashSearches)
+	_ = c.Stasher.Read(stashKeySearches, &searches)
+	for _, searchKey := range searchKeys {
+		delete(searches, searchKey)
+		if err := c.Stasher.Delete(c.employeeSearchKey(searchKey)); err != nil {
+			//KIM: the search may have already been evicted
+			continue
+		}
+		c.Trace(ctx, "invalidated cached employee search: %s", searchKey)
+	}
+	if _, err := c.Stasher.Write(stashKeySearches, &searches); err != nil {
+		return err
+	}
+	return c.deleteMarkers(ctx, stashMarkers{searchKeys: searchKeys})
 }
 
 func (c *stashCache) SleepRead(ctx context.Context, sleepId string) (*data.Sleep, error) {
-	return nil, errors.New("not supported")
+	sleep := &data.Sleep{}
+	if err := c.Stasher.Read(c.sleepKey(sleepId), sleep); err == nil {
+		return sleep, nil
+	}
+	if c.config.inProgressEnabled {
+		token, ok, err := c.markers.grantLease(ctx, entryTypeSleep, sleepId)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, ErrSleepReadAlreadySet
+		}
+		setLeaseCtx(ctx, token)
+		return nil, ErrSleepReadSet
+	}
+	return nil, ErrSleepNotCached
 }
 
 func (c *stashCache) SleepWrite(ctx context.Context, sleep *data.Sleep) error {
-	return errors.New("not supported")
+	markers := stashMarkers{sleepIds: []string{sleep.Id}}
+	if token, ok := LeaseFromCtx(ctx); ok && c.config.inProgressEnabled {
+		leaseHeld, err := c.markers.leaseHeld(ctx, token, markers)
+		if err != nil {
+			return err
+		}
+		if !leaseHeld {
+			return ErrLeaseInvalid
+		}
+	}
+	if _, err := c.Stasher.Write(c.sleepKey(sleep.Id), sleep); err != nil {
+		return err
+	}
+	if err := c.writeSleeps(true, sleep.Id); err != nil {
+		c.Error(ctx, "error while writing cached sleeps: %s", err)
+	}
+	if err := c.deleteMarkers(ctx, markers); err != nil {
+		c.Error(ctx, "error while deleting sleep markers: %s", err)
+	}
+	return nil
 }
 
 func (c *stashCache) SleepsDelete(ctx context.Context, sleepIds ...string) error {
-	return errors.New("not supported")
+	for _, sleepId := range sleepIds {
+		if err := c.Stasher.Delete(c.sleepKey(sleepId)); err != nil {
+			//KIM: the sleep may have already been evicted
+			continue
+		}
+		c.Trace(ctx, "evicted cached sleep: %s", sleepId)
+	}
+	if err := c.writeSleeps(false, sleepIds...); err != nil {
+		c.Error(ctx, "error while writing cached sleeps: %s", err)
+	}
+	return c.deleteMarkers(ctx, stashMarkers{sleepIds: sleepIds})
+}
+
+// KeysRead lists keys using the registries of cached employees, searches
+// and sleeps, the stash doesn't expose when entries expire, so the ttl of
+// entries (and the age of searches) is unknown
+func (c *stashCache) KeysRead(ctx context.Context, entryType string) ([]data.CacheKey, error) {
+	var keys []data.CacheKey
+
+	types, err := entryTypes(entryType)
+	if err != nil {
+		return nil, err
+	}
+	c.Lock()
+	defer c.Unlock()
+
+	//KIM: entries in the registries may have since been evicted by the
+	// stash, so each entry is read to confirm that it still exists
+	for _, t := range types {
+		switch t {
+		case entryTypeEmployee:
+			employees := make(stashEmployees)
+			_ = c.Stasher.Read(stashKeyEmployees, &employees)
+			for empNo, cachedAt := range employees {
+				if err := c.Stasher.Read(c.employeeKey(empNo), &data.Employee{}); err != nil {
+					continue
+				}
+				keys = append(keys, data.CacheKey{
+					EntryType: t,
+					Key:       strconv.FormatInt(empNo, 10),
+					CachedAt:  cachedAt,
+					Age:       time.Since(time.Unix(0, cachedAt)).Milliseconds(),
+					TTL:       -1,
+				})
+			}
+		case entryTypeEmployeeSearch:
+			searches := make(stashSearches)
+			_ = c.Stasher.Read(stashKeySearches, &searches)
+			for searchKey := range searches {
+				if err := c.Stasher.Read(c.employeeSearchKey(searchKey), &stashEmployeeSearch{}); err != nil {
+					continue
+				}
+				keys = append(keys, data.CacheKey{
+					EntryType: t,
+					Key:       searchKey,
+					Age:       -1,
+					TTL:       -1,
+				})
+			}
+		case entryTypeSleep:
+			sleeps := make(stashSleeps)
+			_ = c.Stasher.Read(stashKeySleeps, &sleeps)
+			for sleepId, cachedAt := range sleeps {
+				if err := c.Stasher.Read(c.sleepKey(sleepId), &data.Sleep{}); err != nil {
+					continue
+				}
+				keys = append(keys, data.CacheKey{
+					EntryType: t,
+					Key:       sleepId,
+					CachedAt:  cachedAt,
+					Age:       time.Since(time.Unix(0, cachedAt)).Milliseconds(),
+					TTL:       -1,
+				})
+			}
+		}
+	}
+	return keys, nil
+}
+
+func (c *stashCache) EntryRead(ctx context.Context, entryType, key string) (*data.CacheEntry, error) {
+	var value stash.Cacheable
+	var stashKey string
+
+	cacheKey := data.CacheKey{
+		EntryType: entryType,
+		Key:       key,
+		Age:       -1,
+		TTL:       -1,
+	}
+	switch entryType {
+	default:
+		return nil, ErrCacheEntryTypeUnsupported
+	case entryTypeEmployee:
+		empNo, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		c.Lock()
+		employees := make(stashEmployees)
+		_ = c.Stasher.Read(stashKeyEmployees, &employees)
+		c.Unlock()
+		if cachedAt, ok := employees[empNo]; ok {
+			cacheKey.CachedAt = cachedAt
+			cacheKey.Age = time.Since(time.Unix(0, cachedAt)).Milliseconds()
+		}
+		stashKey, value = c.employeeKey(empNo), &data.Employee{}
+	case entryTypeEmployeeSearch:
+		stashKey, value = c.employeeSearchKey(key), &stashEmployeeSearch{}
+	case entryTypeSleep:
+		c.Lock()
+		sleeps := make(stashSleeps)
+		_ = c.Stasher.Read(stashKeySleeps, &sleeps)
+		c.Unlock()
+		if cachedAt, ok := sleeps[key]; ok {
+			cacheKey.CachedAt = cachedAt
+			cacheKey.Age = time.Since(time.Unix(0, cachedAt)).Milliseconds()
+		}
+		stashKey, value = c.sleepKey(key), &data.Sleep{}
+	}
+	if err := c.Stasher.Read(stashKey, value); err != nil {
+		return nil, ErrCacheEntryNotFound
+	}
+	bytes, err := value.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return &data.CacheEntry{
+		CacheKey: cacheKey,
+		Value:    bytes,
+	}, nil
+}
+
+func (c *stashCache) EntryDelete(ctx context.Context, entryType, key string) error {
+	return entryDelete(ctx, c, entryType, key)
 }
@@ -0,0 +1,501 @@
+package cache
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/antonio-alexander/go-blog-cache/internal/data"
+
+	"github.com/antonio-alexander/go-stash"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	stashKeyInProgress       string = "in_progress"
+	stashKeyNotFound         string = "not_found"
+	stashKeyNotFoundSearches string = "not_found_searches"
+	stashDefaultHashKey      string = "gostash_redis" //the default hash key of go-stash's redis stash
+)
+
+// stashMarkers identifies the in progress and/or not found markers of
+// employees, searches and sleeps
+type stashMarkers struct {
+	empNos     []int64
+	searchKeys []string
+	sleepIds   []string
+}
+
+// keys returns the keys of the markers by entry type
+func (m stashMarkers) keys() map[string][]string {
+	empNos := make([]string, 0, len(m.empNos))
+	for _, empNo := range m.empNos {
+		empNos = append(empNos, strconv.FormatInt(empNo, 10))
+	}
+	return map[string][]string{
+		entryTypeEmployee:       empNos,
+		entryTypeEmployeeSearch: m.searchKeys,
+		entryTypeSleep:          m.sleepIds,
+	}
+}
+
+// stashMarkerStore stores the in progress leases and not found markers of
+// the stash, each operation is atomic with respect to every process that
+// shares the store
+type stashMarkerStore interface {
+	// grantLease will grant a lease for the entry if one isn't held (or
+	// the one held has expired) and return its token, false is returned
+	// if a lease is already held
+	grantLease(ctx context.Context, entryType, key string) (string, bool, error)
+
+	// leaseHeld returns true if the token holds an unexpired lease for
+	// any of the markers
+	leaseHeld(ctx context.Context, token string, markers stashMarkers) (bool, error)
+
+	// deleteInProgress will remove the in progress leases of the markers
+	deleteInProgress(ctx context.Context, markers stashMarkers) error
+
+	// notFound returns true if the entry is marked as not found
+	notFound(ctx context.Context, entryType, key string) (bool, error)
+
+	// writeNotFound will mark the search and employees as not found
+	writeNotFound(ctx context.Context, searchKey string, search data.EmployeeSearch, empNos ...int64) error
+
+	// notFoundSearches returns the criteria of the searches marked as
+	// not found by search key
+	notFoundSearches(ctx context.Context) (map[string]data.EmployeeSearch, error)
+
+	// deleteNotFound will remove the not found markers
+	deleteNotFound(ctx context.Context, markers stashMarkers) error
+
+	// clear will remove all of the markers
+	clear(ctx context.Context) error
+}
+
+// stashLeases are leases by key, leases are stored as strings since
+// their fields aren't exported
+type stashLeases map[string]lease
+
+func (l stashLeases) MarshalJSON() ([]byte, error) {
+	leases := make(map[string]string, len(l))
+	for key, lease := range l {
+		leases[key] = lease.String()
+	}
+	return json.Marshal(leases)
+}
+
+func (l *stashLeases) UnmarshalJSON(bytes []byte) error {
+	leases := make(map[string]string)
+	if err := json.Unmarshal(bytes, &leases); err != nil {
+		return err
+	}
+	*l = make(stashLeases, len(leases))
+	for key, s := range leases {
+		(*l)[key] = parseLease(s)
+	}
+	return nil
+}
+
+// stashInProgress is the leases of fills that are in progress
+type stashInProgress struct {
+	EmployeeRead   stashLeases `json:"employee_read"`
+	EmployeeSearch stashLeases `json:"employee_search"`
+	SleepRead      stashLeases `json:"sleep_read"`
+}
+
+func (s *stashInProgress) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *stashInProgress) UnmarshalBinary(bytes []byte) error {
+	return json.Unmarshal(bytes, s)
+}
+
+// leases returns the leases of the entry type
+func (s *stashInProgress) leases(entryType string) stashLeases {
+	switch entryType {
+	default:
+		return s.SleepRead
+	case entryTypeEmployee:
+		return s.EmployeeRead
+	case entryTypeEmployeeSearch:
+		return s.EmployeeSearch
+	}
+}
+
+type stashNotFoundSearch struct {
+	Search   data.EmployeeSearch `json:"search"`
+	CachedAt int64               `json:"cached_at"`
+}
+
+func (s *stashNotFoundSearch) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *stashNotFoundSearch) UnmarshalBinary(bytes []byte) error {
+	return json.Unmarshal(bytes, s)
+}
+
+// stashNotFound is when each employee (or search) was cached as not found
+type stashNotFound struct {
+	EmployeeNotFound       map[string]int64               `json:"employee_not_found"`
+	EmployeeSearchNotFound map[string]stashNotFoundSearch `json:"employee_search_not_found"`
+}
+
+func (s *stashNotFound) MarshalBinary() ([]byte, error) {
+	return json.Marshal(s)
+}
+
+func (s *stashNotFound) UnmarshalBinary(bytes []byte) error {
+	return json.Unmarshal(bytes, s)
+}
+
+// stashLocalMarkers stores the markers in the stash itself, they're read,
+// modified and written back under a lock that only applies to this
+// process, so it can only be used with a stash that isn't shared (e.g.
+// memory)
+type stashLocalMarkers struct {
+	sync.Mutex
+	stash.Stasher
+	inProgressTTL time.Duration
+	notFoundTTL   time.Duration
+}
+
+// readInProgress will read the in progress leases, the lock should
+// be held
+func (m *stashLocalMarkers) readInProgress() *stashInProgress {
+	inProgress := &stashInProgress{}
+	_ = m.Stasher.Read(stashKeyInProgress, inProgress)
+	if inProgress.EmployeeRead == nil {
+		inProgress.EmployeeRead = make(stashLeases)
+	}
+	if inProgress.EmployeeSearch == nil {
+		inProgress.EmployeeSearch = make(stashLeases)
+	}
+	if inProgress.SleepRead == nil {
+		inProgress.SleepRead = make(stashLeases)
+	}
+	return inProgress
+}
+
+// readNotFound will read the not found markers, markers that have
+// expired are omitted; the lock should be held
+func (m *stashLocalMarkers) readNotFound() *stashNotFound {
+	notFound := &stashNotFound{}
+	_ = m.Stasher.Read(stashKeyNotFound, notFound)
+	if notFound.EmployeeNotFound == nil {
+		notFound.EmployeeNotFound = make(map[string]int64)
+	}
+	if notFound.EmployeeSearchNotFound == nil {
+		notFound.EmployeeSearchNotFound = make(map[string]stashNotFoundSearch)
+	}
+	//KIM: there's no prune, so expired markers are removed when read
+	// and are written back with the next change
+	for empNo, cachedAt := range notFound.EmployeeNotFound {
+		if time.Since(time.Unix(0, cachedAt)) > m.notFoundTTL {
+			delete(notFound.EmployeeNotFound, empNo)
+		}
+	}
+	for searchKey, search := range notFound.EmployeeSearchNotFound {
+		if time.Since(time.Unix(0, search.CachedAt)) > m.notFoundTTL {
+			delete(notFound.EmployeeSearchNotFound, searchKey)
+		}
+	}
+	return notFound
+}
+
+func (m *stashLocalMarkers) grantLease(ctx context.Context, entryType, key string) (string, bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	inProgress := m.readInProgress()
+	l, ok := grantLease(inProgress.leases(entryType), key, m.inProgressTTL)
+	if !ok {
+		return "", false, nil
+	}
+	if _, err := m.Stasher.Write(stashKeyInProgress, inProgress); err != nil {
+		return "", false, err
+	}
+	return l.token, true, nil
+}
+
+func (m *stashLocalMarkers) leaseHeld(ctx context.Context, token string, markers stashMarkers) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	inProgress := m.readInProgress()
+	for entryType, keys := range markers.keys() {
+		if leaseHeld(inProgress.leases(entryType), token, m.inProgressTTL, keys...) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *stashLocalMarkers) deleteInProgress(ctx context.Context, markers stashMarkers) error {
+	m.Lock()
+	defer m.Unlock()
+
+	inProgress := m.readInProgress()
+	for entryType, keys := range markers.keys() {
+		leases := inProgress.leases(entryType)
+		for _, key := range keys {
+			delete(leases, key)
+		}
+	}
+	_, err := m.Stasher.Write(stashKeyInProgress, inProgress)
+	return err
+}
+
+func (m *stashLocalMarkers) notFound(ctx context.Context, entryType, key string) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	var ok bool
+
+	notFound := m.readNotFound()
+	switch entryType {
+	case entryTypeEmployee:
+		_, ok = notFound.EmployeeNotFound[key]
+	case entryTypeEmployeeSearch:
+		_, ok = notFound.EmployeeSearchNotFound[key]
+	}
+	return ok, nil
+}
+
+func (m *stashLocalMarkers) writeNotFound(ctx context.Context, searchKey string, search data.EmployeeSearch, empNos ...int64) error {
+	m.Lock()
+	defer m.Unlock()
+
+	tNow := time.Now().UnixNano()
+	notFound := m.readNotFound()
+	if _, ok := notFound.EmployeeSearchNotFound[searchKey]; !ok {
+		notFound.EmployeeSearchNotFound[searchKey] = stashNotFoundSearch{
+			Search:   search,
+			CachedAt: tNow,
+		}
+	}
+	for _, empNo := range empNos {
+		notFound.EmployeeNotFound[strconv.FormatInt(empNo, 10)] = tNow
+	}
+	_, err := m.Stasher.Write(stashKeyNotFound, notFound)
+	return err
+}
+
+func (m *stashLocalMarkers) notFoundSearches(ctx context.Context) (map[string]data.EmployeeSearch, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	searches := make(map[string]data.EmployeeSearch)
+	for searchKey, search := range m.readNotFound().EmployeeSearchNotFound {
+		searches[searchKey] = search.Search
+	}
+	return searches, nil
+}
+
+func (m *stashLocalMarkers) deleteNotFound(ctx context.Context, markers stashMarkers) error {
+	m.Lock()
+	defer m.Unlock()
+
+	notFound := m.readNotFound()
+	keys := markers.keys()
+	for _, empNo := range keys[entryTypeEmployee] {
+		delete(notFound.EmployeeNotFound, empNo)
+	}
+	for _, searchKey := range keys[entryTypeEmployeeSearch] {
+		delete(notFound.EmployeeSearchNotFound, searchKey)
+	}
+	_, err := m.Stasher.Write(stashKeyNotFound, notFound)
+	return err
+}
+
+// clear is a no-op, the markers are cleared with the stash
+func (m *stashLocalMarkers) clear(ctx context.Context) error {
+	return nil
+}
+
+// stashRedisClient is the part of the redis client (embedded by go-stash's
+// redis stash) that's used to store the markers
+type stashRedisClient interface {
+	Ping(ctx context.Context) *redis.StatusCmd
+	Set(ctx context.Context, key string, value any, expiration time.Duration) *redis.StatusCmd
+	SetNX(ctx context.Context, key string, value any, expiration time.Duration) *redis.BoolCmd
+	MGet(ctx context.Context, keys ...string) *redis.SliceCmd
+	Exists(ctx context.Context, keys ...string) *redis.IntCmd
+	Del(ctx context.Context, keys ...string) *redis.IntCmd
+	HSet(ctx context.Context, key string, values ...any) *redis.IntCmd
+	HGetAll(ctx context.Context, key string) *redis.MapStringStringCmd
+	HDel(ctx context.Context, key string, fields ...string) *redis.IntCmd
+	Scan(ctx context.Context, cursor uint64, match string, count int64) *redis.ScanCmd
+}
+
+// stashRedisMarkers stores each marker as its own key in the redis the
+// stash is stored in (next to the stash's hash), leases are granted with
+// SET NX and markers expire with their ttl, so the markers can be shared
+// by every process that shares the stash
+type stashRedisMarkers struct {
+	client        stashRedisClient
+	prefix        string
+	inProgressTTL time.Duration
+	notFoundTTL   time.Duration
+}
+
+func (m *stashRedisMarkers) inProgressKey(entryType, key string) string {
+	return m.prefix + stashKeyInProgress + ":" + entryType + ":" + key
+}
+
+func (m *stashRedisMarkers) notFoundKey(entryType, key string) string {
+	return m.prefix + stashKeyNotFound + ":" + entryType + ":" + key
+}
+
+// notFoundSearchesKey is the key of the hash of the criteria of the
+// searches marked as not found, the hash is pruned when it's read
+func (m *stashRedisMarkers) notFoundSearchesKey() string {
+	return m.prefix + stashKeyNotFoundSearches
+}
+
+func (m *stashRedisMarkers) grantLease(ctx context.Context, entryType, key string) (string, bool, error) {
+	l := newLease()
+	ok, err := m.client.SetNX(ctx, m.inProgressKey(entryType, key), l.String(), m.inProgressTTL).Result()
+	if err != nil || !ok {
+		return "", false, err
+	}
+	return l.token, true, nil
+}
+
+func (m *stashRedisMarkers) leaseHeld(ctx context.Context, token string, markers stashMarkers) (bool, error) {
+	var keys []string
+
+	for entryType, markerKeys := range markers.keys() {
+		for _, key := range markerKeys {
+			keys = append(keys, m.inProgressKey(entryType, key))
+		}
+	}
+	if len(keys) == 0 {
+		return false, nil
+	}
+	values, err := m.client.MGet(ctx, keys...).Result()
+	if err != nil {
+		return false, err
+	}
+	for _, value := range values {
+		if s, ok := value.(string); ok && parseLease(s).token == token {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *stashRedisMarkers) deleteInProgress(ctx context.Context, markers stashMarkers) error {
+	var keys []string
+
+	for entryType, markerKeys := range markers.keys() {
+		for _, key := range markerKeys {
+			keys = append(keys, m.inProgressKey(entryType, key))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return m.client.Del(ctx, keys...).Err()
+}
+
+func (m *stashRedisMarkers) notFound(ctx context.Context, entryType, key string) (bool, error) {
+	n, err := m.client.Exists(ctx, m.notFoundKey(entryType, key)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (m *stashRedisMarkers) writeNotFound(ctx context.Context, searchKey string, search data.EmployeeSearch, empNos ...int64) error {
+	tNow := time.Now().UnixNano()
+	//KIM: like the local markers, a search that's already marked keeps
+	// the time it was first marked
+	ok, err := m.client.SetNX(ctx, m.notFoundKey(entryTypeEmployeeSearch, searchKey),
+		tNow, m.notFoundTTL).Result()
+	if err != nil {
+		return err
+	}
+	if ok {
+		if err := m.client.HSet(ctx, m.notFoundSearchesKey(), searchKey, &stashNotFoundSearch{
+			Search:   search,
+			CachedAt: tNow,
+		}).Err(); err != nil {
+			return err
+		}
+	}
+	for _, empNo := range empNos {
+		if err := m.client.Set(ctx, m.notFoundKey(entryTypeEmployee,
+			strconv.FormatInt(empNo, 10)), tNow, m.notFoundTTL).Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *stashRedisMarkers) notFoundSearches(ctx context.Context) (map[string]data.EmployeeSearch, error) {
+	var expired []string
+
+	values, err := m.client.HGetAll(ctx, m.notFoundSearchesKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	searches := make(map[string]data.EmployeeSearch, len(values))
+	for searchKey, value := range values {
+		var search stashNotFoundSearch
+		if err := search.UnmarshalBinary([]byte(value)); err != nil {
+			return nil, err
+		}
+		if time.Since(time.Unix(0, search.CachedAt)) > m.notFoundTTL {
+			expired = append(expired, searchKey)
+			continue
+		}
+		searches[searchKey] = search.Search
+	}
+	if len(expired) > 0 {
+		if err := m.client.HDel(ctx, m.notFoundSearchesKey(), expired...).Err(); err != nil {
+			return nil, err
+		}
+	}
+	return searches, nil
+}
+
+func (m *stashRedisMarkers) deleteNotFound(ctx context.Context, markers stashMarkers) error {
+	var keys []string
+
+	markerKeys := markers.keys()
+	for _, entryType := range []string{entryTypeEmployee, entryTypeEmployeeSearch} {
+		for _, key := range markerKeys[entryType] {
+			keys = append(keys, m.notFoundKey(entryType, key))
+		}
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	if err := m.client.Del(ctx, keys...).Err(); err != nil {
+		return err
+	}
+	if searchKeys := markerKeys[entryTypeEmployeeSearch]; len(searchKeys) > 0 {
+		return m.client.HDel(ctx, m.notFoundSearchesKey(), searchKeys...).Err()
+	}
+	return nil
+}
+
+func (m *stashRedisMarkers) clear(ctx context.Context) error {
+	var keys []string
+
+	scanIter := m.client.Scan(ctx, 0, m.prefix+"*", 0).Iterator()
+	for scanIter.Next(ctx) {
+		keys = append(keys, scanIter.Val())
+	}
+	if err := scanIter.Err(); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	return m.client.Del(ctx, keys...).Err()
+}
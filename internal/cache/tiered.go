@@ -0,0 +1,308 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/antonio-alexander/go-blog-cache/internal"
+	"github.com/antonio-alexander/go-blog-cache/internal/data"
+	"github.com/antonio-alexander/go-blog-cache/internal/utilities"
+)
+
+const (
+	tierL1 string = "l1"
+	tierL2 string = "l2"
+)
+
+type tieredCache struct {
+	l1 interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		Cache
+		Inspector
+	}
+	l2 interface {
+		internal.Configurer
+		internal.Opener
+		internal.Clearer
+		Cache
+		Inspector
+		Versioner
+		Waiter
+	}
+	logger  utilities.Logger
+	counter utilities.Counter
+}
+
+// NewTiered creates a cache that uses an in-process memory cache (l1)
+// in front of a redis cache (l2); reads go through l1, then l2 and
+// any hit in l2 will populate l1
+func NewTiered(parameters ...any) interface {
+	internal.Configurer
+	internal.Opener
+	internal.Clearer
+	Cache
+	Inspector
+	Versioner
+	Waiter
+} {
+	c := &tieredCache{
+		l1: NewMemory(parameters...),
+		l2: NewRedis(parameters...),
+	}
+	for _, parameter := range parameters {
+		switch p := parameter.(type) {
+		case utilities.Logger:
+			c.logger = p
+		case utilities.Counter:
+			c.counter = p
+		}
+	}
+	return c
+}
+
+func (c *tieredCache) Error(ctx context.Context, format string, v ...any) {
+	if c.logger != nil {
+		c.logger.Error(ctx, format, v...)
+	}
+}
+
+func (c *tieredCache) Info(ctx context.Context, format string, v ...any) {
+	if c.logger != nil {
+		c.logger.Info(ctx, format, v...)
+	}
+}
+
+func (c *tieredCache) Trace(ctx context.Context, format string, v ...any) {
+	if c.logger != nil {
+		c.logger.Trace(ctx, format, v...)
+	}
+}
+
+func (c *tieredCache) incrementHit(tier, format string, v ...any) {
+	if c.counter != nil {
+		c.counter.IncrementHit(tier + "_" + fmt.Sprintf(format, v...))
+	}
+}
+
+func (c *tieredCache) incrementMiss(tier, format string, v ...any) {
+	if c.counter != nil {
+		c.counter.IncrementMiss(tier + "_" + fmt.Sprintf(format, v...))
+	}
+}
+
+func (c *tieredCache) Configure(envs map[string]string) error {
+	//KIM: l1 is intentionally short lived and doesn't participate in
+	// in progress or not found caching, those are owned by l2 since
+	// it's shared between instances
+	l1Envs := make(map[string]string)
+	for key, value := range envs {
+		l1Envs[key] = value
+	}
+	l1Envs["CACHE_TTL"] = "1"
+	if s, ok := envs["CACHE_TIERED_L1_TTL"]; ok && s != "" {
+		l1Envs["CACHE_TTL"] = s
+	}
+	l1Envs["CACHE_ENABLE_IN_PROGRESS"] = "false"
+	l1Envs["CACHE_NOT_FOUND_ENABLED"] = "false"
+	l1Envs["CACHE_HARD_TTL"] = "0"
+	l1Envs["CACHE_SNAPSHOT_FILE"] = ""
+	if err := c.l1.Configure(l1Envs); err != nil {
+		return err
+	}
+	if err := c.l2.Configure(envs); err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *tieredCache) Open(ctx context.Context) error {
+	if err := c.l2.Open(ctx); err != nil {
+		return err
+	}
+	if err := c.l1.Open(ctx); err != nil {
+		if err := c.l2.Close(ctx); err != nil {
+			c.Error(ctx, "error while closing cache (l2): %s", err)
+		}
+		return err
+	}
+	c.Info(ctx, "cache: tiered enabled")
+	return nil
+}
+
+func (c *tieredCache) Close(ctx context.Context) error {
+	if err := c.l1.Close(ctx); err != nil {
+		c.Error(ctx, "error while closing cache (l1): %s", err)
+	}
+	if err := c.l2.Close(ctx); err != nil {
+		c.Error(ctx, "error while closing cache (l2): %s", err)
+	}
+	return nil
+}
+
+func (c *tieredCache) Clear(ctx context.Context) error {
+	if err := c.l2.Clear(ctx); err != nil {
+		return err
+	}
+	return c.l1.Clear(ctx)
+}
+
+func (c *tieredCache) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee, error) {
+	employee, err := c.l1.EmployeeRead(ctx, empNo)
+	if err == nil {
+		c.incrementHit(tierL1, "employee_%d", empNo)
+		return employee, nil
+	}
+	c.incrementMiss(tierL1, "employee_%d", empNo)
+	employee, err = c.l2.EmployeeRead(ctx, empNo)
+	if errors.Is(err, data.ErrStale) || errors.Is(err, data.ErrRefresh) {
+		//KIM: stale entries (or entries due an early refresh) aren't
+		// written to l1, otherwise they'd outlive their revalidation in l2
+		c.incrementHit(tierL2, "employee_%d", empNo)
+		return employee, err
+	}
+	if err != nil {
+		c.incrementMiss(tierL2, "employee_%d", empNo)
+		return nil, err
+	}
+	c.incrementHit(tierL2, "employee_%d", empNo)
+	if err := c.l1.EmployeesWrite(ctx, data.EmployeeSearch{}, employee); err != nil {
+		c.Trace(ctx, "error while writing employee (%d) to cache (l1): %s", empNo, err)
+	}
+	return employee, nil
+}
+
+func (c *tieredCache) EmployeesRead(ctx context.Context, search data.EmployeeSearch) ([]*data.Employee, error) {
+	searchKey, err := search.ToKey()
+	if err != nil {
+		return nil, err
+	}
+	employees, err := c.l1.EmployeesRead(ctx, search)
+	if err == nil {
+		c.incrementHit(tierL1, "employee_search_%s", searchKey)
+		return employees, nil
+	}
+	c.incrementMiss(tierL1, "employee_search_%s", searchKey)
+	employees, err = c.l2.EmployeesRead(ctx, search)
+	if errors.Is(err, data.ErrStale) || errors.Is(err, data.ErrRefresh) {
+		c.incrementHit(tierL2, "employee_search_%s", searchKey)
+		return employees, err
+	}
+	if err != nil {
+		c.incrementMiss(tierL2, "employee_search_%s", searchKey)
+		return nil, err
+	}
+	c.incrementHit(tierL2, "employee_search_%s", searchKey)
+	if err := c.l1.EmployeesWrite(ctx, search, employees...); err != nil {
+		c.Trace(ctx, "error while writing employees (%s) to cache (l1): %s", searchKey, err)
+	}
+	return employees, nil
+}
+
+func (c *tieredCache) EmployeesWrite(ctx context.Context, search data.EmployeeSearch, employees ...*data.Employee) error {
+	if err := c.l2.EmployeesWrite(ctx, search, employees...); err != nil {
+		return err
+	}
+	//KIM: the generation of a fill is read from l2 (see Generation), so
+	// once l2 accepts the write l1 shouldn't compare it to its own
+	return c.l1.EmployeesWrite(ctxWithoutGeneration(ctx), search, employees...)
+}
+
+func (c *tieredCache) EmployeesDelete(ctx context.Context, empNos ...int64) error {
+	if err := c.l2.EmployeesDelete(ctx, empNos...); err != nil {
+		return err
+	}
+	return c.l1.EmployeesDelete(ctx, empNos...)
+}
+
+func (c *tieredCache) EmployeesNotFoundWrite(ctx context.Context, search data.EmployeeSearch, empNos ...int64) error {
+	return c.l2.EmployeesNotFoundWrite(ctx, search, empNos...)
+}
+
+func (c *tieredCache) EmployeeSearchesRead(ctx context.Context) (map[string]data.EmployeeSearch, error) {
+	searches, err := c.l2.EmployeeSearchesRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	//KIM: l1 can briefly hold searches that have already expired in l2
+	l1Searches, err := c.l1.EmployeeSearchesRead(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for searchKey, search := range l1Searches {
+		searches[searchKey] = search
+	}
+	return searches, nil
+}
+
+func (c *tieredCache) EmployeeSearchesDelete(ctx context.Context, searchKeys ...string) error {
+	if err := c.l2.EmployeeSearchesDelete(ctx, searchKeys...); err != nil {
+		return err
+	}
+	return c.l1.EmployeeSearchesDelete(ctx, searchKeys...)
+}
+
+func (c *tieredCache) SleepRead(ctx context.Context, sleepId string) (*data.Sleep, error) {
+	sleep, err := c.l1.SleepRead(ctx, sleepId)
+	if err == nil {
+		c.incrementHit(tierL1, "sleep_%s", sleepId)
+		return sleep, nil
+	}
+	c.incrementMiss(tierL1, "sleep_%s", sleepId)
+	sleep, err = c.l2.SleepRead(ctx, sleepId)
+	if errors.Is(err, data.ErrStale) || errors.Is(err, data.ErrRefresh) {
+		c.incrementHit(tierL2, "sleep_%s", sleepId)
+		return sleep, err
+	}
+	if err != nil {
+		c.incrementMiss(tierL2, "sleep_%s", sleepId)
+		return nil, err
+	}
+	c.incrementHit(tierL2, "sleep_%s", sleepId)
+	if err := c.l1.SleepWrite(ctx, sleep); err != nil {
+		c.Trace(ctx, "error while writing sleep (%s) to cache (l1): %s", sleepId, err)
+	}
+	return sleep, nil
+}
+
+func (c *tieredCache) SleepWrite(ctx context.Context, sleep *data.Sleep) error {
+	if err := c.l2.SleepWrite(ctx, sleep); err != nil {
+		return err
+	}
+	return c.l1.SleepWrite(ctxWithoutGeneration(ctx), sleep)
+}
+
+func (c *tieredCache) SleepsDelete(ctx context.Context, sleepIds ...string) error {
+	if err := c.l2.SleepsDelete(ctx, sleepIds...); err != nil {
+		return err
+	}
+	return c.l1.SleepsDelete(ctx, sleepIds...)
+}
+
+// Generation returns the generation of l2, writes are accepted (or
+// rejected) by l2 before they're written to l1
+func (c *tieredCache) Generation(ctx context.Context) (int64, error) {
+	return c.l2.Generation(ctx)
+}
+
+// FillWait waits on the fill in l2, reads only set in progress (and
+// hand out leases) in l2
+func (c *tieredCache) FillWait(ctx context.Context, entryType, key string) error {
+	return c.l2.FillWait(ctx, entryType, key)
+}
+
+// KeysRead lists the keys in l2; l1 only ever holds a subset of
+// l2 (for a shorter ttl) so l2 is the source of truth
+func (c *tieredCache) KeysRead(ctx context.Context, entryType string) ([]data.CacheKey, error) {
+	return c.l2.KeysRead(ctx, entryType)
+}
+
+func (c *tieredCache) EntryRead(ctx context.Context, entryType, key string) (*data.CacheEntry, error) {
+	return c.l2.EntryRead(ctx, entryType, key)
+}
+
+func (c *tieredCache) EntryDelete(ctx context.Context, entryType, key string) error {
+	return entryDelete(ctx, c, entryType, key)
+}
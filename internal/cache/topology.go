@@ -0,0 +1,245 @@
+package cache
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/antonio-alexander/go-blog-cache/internal"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisMode is the topology of the redis deployment the cache connects to
+type redisMode string
+
+const (
+	redisModeStandalone redisMode = "standalone"
+	redisModeSentinel   redisMode = "sentinel"
+	redisModeCluster    redisMode = "cluster"
+)
+
+// parseRedisMode returns the redis mode for the given name, an empty
+// name is standalone
+func parseRedisMode(s string) (redisMode, error) {
+	switch mode := redisMode(s); mode {
+	default:
+		return "", fmt.Errorf("redis mode (%s) not supported", s)
+	case "":
+		return redisModeStandalone, nil
+	case redisModeStandalone, redisModeSentinel, redisModeCluster:
+		return mode, nil
+	}
+}
+
+// parseAddresses will parse addresses (host:port) separated by commas
+// and/or whitespace
+func parseAddresses(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	})
+}
+
+// redisAuth is the (acl) username and tls configuration used by
+// anything that connects to redis
+type redisAuth struct {
+	username      string
+	tlsEnabled    bool
+	tlsCaFile     string
+	tlsCrtFile    string
+	tlsKeyFile    string
+	tlsServerName string
+}
+
+func (a *redisAuth) configure(envs map[string]string) {
+	if s, ok := envs["REDIS_USERNAME"]; ok {
+		a.username = s
+	}
+	if s, ok := envs["REDIS_TLS_ENABLED"]; ok {
+		a.tlsEnabled, _ = strconv.ParseBool(s)
+	}
+	if s, ok := envs["REDIS_TLS_CA_FILE"]; ok {
+		a.tlsCaFile = s
+	}
+	if s, ok := envs["REDIS_TLS_CRT_FILE"]; ok {
+		a.tlsCrtFile = s
+	}
+	if s, ok := envs["REDIS_TLS_KEY_FILE"]; ok {
+		a.tlsKeyFile = s
+	}
+	if s, ok := envs["REDIS_TLS_SERVER_NAME"]; ok {
+		a.tlsServerName = s
+	}
+}
+
+// tlsConfig returns the tls configuration for connections to redis, if
+// tls isn't enabled no configuration (and no error) is returned
+func (a *redisAuth) tlsConfig() (*tls.Config, error) {
+	if !a.tlsEnabled {
+		return nil, nil
+	}
+	tlsConfig, err := internal.GetTlsConfig(a.tlsCrtFile, a.tlsKeyFile, a.tlsCaFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig.ServerName = a.tlsServerName
+	return tlsConfig, nil
+}
+
+// newClient creates a client for the configured redis mode; sentinel
+// clients connect to the master found through the sentinels and
+// cluster clients discover the cluster from the seed nodes
+func (c *redisCache) newClient() (redis.UniversalClient, error) {
+	tlsConfig, err := c.config.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	switch c.config.mode {
+	default:
+		return redis.NewClient(&redis.Options{
+			Addr:      net.JoinHostPort(c.config.address, c.config.port),
+			Username:  c.config.username,
+			Password:  c.config.password,
+			DB:        c.config.database,
+			TLSConfig: tlsConfig,
+		}), nil
+	case redisModeSentinel:
+		if c.config.sentinelMaster == "" || len(c.config.sentinelAddresses) == 0 {
+			return nil, fmt.Errorf("redis sentinel master and addresses required")
+		}
+		return redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       c.config.sentinelMaster,
+			SentinelAddrs:    c.config.sentinelAddresses,
+			SentinelPassword: c.config.sentinelPassword,
+			Username:         c.config.username,
+			Password:         c.config.password,
+			DB:               c.config.database,
+			TLSConfig:        tlsConfig,
+		}), nil
+	case redisModeCluster:
+		if len(c.config.clusterAddresses) == 0 {
+			return nil, fmt.Errorf("redis cluster addresses required")
+		}
+		if c.config.database != 0 {
+			return nil, fmt.Errorf("redis cluster only supports database 0")
+		}
+		return redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     c.config.clusterAddresses,
+			Username:  c.config.username,
+			Password:  c.config.password,
+			TLSConfig: tlsConfig,
+		}), nil
+	}
+}
+
+// key returns the key for an individual cached item; each item is
+// stored as its own key so that it can expire independently, in a
+// cluster the id is a hash tag so that any key derived from the key
+// (see derivedKey) hashes to the same slot
+func (c *redisCache) key(prefix string, id any) string {
+	if c.config.mode == redisModeCluster {
+		return c.namespacedKey(prefix) + ":{" + fmt.Sprint(id) + "}"
+	}
+	return c.namespacedKey(prefix) + ":" + fmt.Sprint(id)
+}
+
+// derivedKey returns the key of a record kept for the given key (e.g.
+// its fencing token), in a cluster it hashes to the same slot as the key
+// so a script can use both
+func (c *redisCache) derivedKey(prefix, key string) string {
+	return c.namespacedKey(prefix) + ":" + key
+}
+
+// namespacedKey returns the key within the namespace of the cache (see
+// CACHE_KEY_PREFIX) so deployments that share a database don't read (or
+// clear) each other's keys; key and derivedKey are already namespaced
+func (c *redisCache) namespacedKey(key string) string {
+	return c.config.keyPrefix + key
+}
+
+// parseKeyPrefix validates the key prefix (see CACHE_KEY_PREFIX) and
+// returns it with its separator
+func parseKeyPrefix(s string) (string, error) {
+	//KIM: a hash tag in the prefix would put every key in the same
+	// slot and glob characters would break the scans used by Clear
+	if strings.ContainsAny(s, "{}*?[]\\") {
+		return "", fmt.Errorf("cache key prefix (%s) can't contain hash tags or glob characters", s)
+	}
+	return s + ":", nil
+}
+
+// expiryKey returns the key of the sorted set that tracks when each
+// field of the hash was set, the hash tag keeps it in the same slot as
+// the hash so both can be used by the same script (or transaction)
+func expiryKey(hashKey string) string {
+	return "{" + hashKey + "}:expiry"
+}
+
+// keyId returns the id of the given key (i.e. the inverse of key)
+func (c *redisCache) keyId(prefix, key string) string {
+	id := strings.TrimPrefix(key, c.namespacedKey(prefix)+":")
+	if c.config.mode == redisModeCluster {
+		id = strings.TrimSuffix(strings.TrimPrefix(id, "{"), "}")
+	}
+	return id
+}
+
+// scan returns all keys that match the given pattern, in a cluster every
+// master is scanned since each only holds the keys in its slots
+func (c *redisCache) scan(ctx context.Context, match string) ([]string, error) {
+	var keys []string
+
+	scanFx := func(ctx context.Context, client redis.Cmdable) ([]string, error) {
+		var keys []string
+
+		scanIter := client.Scan(ctx, 0, match, 0).Iterator()
+		for scanIter.Next(ctx) {
+			keys = append(keys, scanIter.Val())
+		}
+		if err := scanIter.Err(); err != nil {
+			return nil, err
+		}
+		return keys, nil
+	}
+	clusterClient, ok := c.redisClient.(*redis.ClusterClient)
+	if !ok {
+		return scanFx(ctx, c.redisClient)
+	}
+	var mu sync.Mutex
+	if err := clusterClient.ForEachMaster(ctx, func(ctx context.Context, client *redis.Client) error {
+		masterKeys, err := scanFx(ctx, client)
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		keys = append(keys, masterKeys...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// del will delete the given keys, in a cluster keys in different slots
+// can't be deleted with a single command so each is deleted individually
+// (but pipelined)
+func (c *redisCache) del(ctx context.Context, keys ...string) error {
+	if len(keys) <= 0 {
+		return nil
+	}
+	if c.config.mode != redisModeCluster {
+		return c.redisClient.Del(ctx, keys...).Err()
+	}
+	_, err := c.redisClient.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		for _, key := range keys {
+			pipe.Del(ctx, key)
+		}
+		return nil
+	})
+	return err
+}
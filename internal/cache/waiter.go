@@ -0,0 +1,71 @@
+package cache
+
+import "sync"
+
+// fillWaiters tracks the readers waiting on fills in progress, waiters
+// are notified once the entry they're waiting on is written or deleted
+// (or its lease expires)
+type fillWaiters struct {
+	sync.Mutex
+	waiters map[string]map[chan struct{}]struct{} //map[entry_type:key]waiters
+}
+
+func newFillWaiters() *fillWaiters {
+	return &fillWaiters{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+func fillWaiterKey(entryType, key string) string {
+	return entryType + ":" + key
+}
+
+// add will add a waiter for the given entry, the returned function must
+// be called to remove the waiter if it wasn't notified
+func (w *fillWaiters) add(entryType, key string) (<-chan struct{}, func()) {
+	w.Lock()
+	defer w.Unlock()
+
+	waiterKey := fillWaiterKey(entryType, key)
+	if _, ok := w.waiters[waiterKey]; !ok {
+		w.waiters[waiterKey] = make(map[chan struct{}]struct{})
+	}
+	waiter := make(chan struct{})
+	w.waiters[waiterKey][waiter] = struct{}{}
+	return waiter, func() {
+		w.Lock()
+		defer w.Unlock()
+
+		delete(w.waiters[waiterKey], waiter)
+		if len(w.waiters[waiterKey]) == 0 {
+			delete(w.waiters, waiterKey)
+		}
+	}
+}
+
+// notify will notify (and remove) all waiters for the given entries
+func (w *fillWaiters) notify(entryType string, keys ...string) {
+	w.Lock()
+	defer w.Unlock()
+
+	for _, key := range keys {
+		waiterKey := fillWaiterKey(entryType, key)
+		for waiter := range w.waiters[waiterKey] {
+			close(waiter)
+		}
+		delete(w.waiters, waiterKey)
+	}
+}
+
+// notifyAll will notify (and remove) all waiters
+func (w *fillWaiters) notifyAll() {
+	w.Lock()
+	defer w.Unlock()
+
+	for _, waiters := range w.waiters {
+		for waiter := range waiters {
+			close(waiter)
+		}
+	}
+	w.waiters = make(map[string]map[chan struct{}]struct{})
+}
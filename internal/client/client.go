@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"sync"
 	"time"
@@ -31,6 +32,9 @@ type Client interface {
 	Sleep(ctx context.Context, sleep data.Sleep) (*data.Sleep, error)
 
 	CacheClear(ctx context.Context) error
+	CacheKeysRead(ctx context.Context, entryType string) ([]data.CacheKey, error)
+	CacheEntryRead(ctx context.Context, entryType, key string) (*data.CacheEntry, error)
+	CacheEntryDelete(ctx context.Context, entryType, key string) error
 	CacheCountersRead(ctx context.Context) (*data.CacheCounters, error)
 	CacheCountersClear(ctx context.Context) error
 
@@ -305,6 +309,46 @@ func (c *client) CacheClear(ctx context.Context) error {
 	return nil
 }
 
+func (c *client) CacheKeysRead(ctx context.Context, entryType string) ([]data.CacheKey, error) {
+	uri := c.address + data.RouteCacheKeys
+	params := url.Values{}
+	if entryType != "" {
+		params.Set(data.ParameterEntryType, entryType)
+	}
+	bytes, err := c.doRequest(ctx, http.MethodGet, uri, params)
+	if err != nil {
+		return nil, err
+	}
+	response := &data.CacheKeys{}
+	if err := json.Unmarshal(bytes, response); err != nil {
+		return nil, err
+	}
+	return response.Keys, nil
+}
+
+func (c *client) CacheEntryRead(ctx context.Context, entryType, key string) (*data.CacheEntry, error) {
+	uri := c.address + fmt.Sprintf(data.RouteCacheEntryf,
+		url.PathEscape(entryType), url.PathEscape(key))
+	bytes, err := c.doRequest(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	response := &data.CacheEntry{}
+	if err := json.Unmarshal(bytes, response); err != nil {
+		return nil, err
+	}
+	return response, nil
+}
+
+func (c *client) CacheEntryDelete(ctx context.Context, entryType, key string) error {
+	uri := c.address + fmt.Sprintf(data.RouteCacheEntryf,
+		url.PathEscape(entryType), url.PathEscape(key))
+	if _, err := c.doRequest(ctx, http.MethodDelete, uri, nil); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (c *client) CacheCountersRead(ctx context.Context) (*data.CacheCounters, error) {
 	uri := c.address + data.RouteCacheCounters
 	bytes, err := c.doRequest(ctx, http.MethodGet, uri, nil)
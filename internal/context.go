@@ -1,9 +1,14 @@
 package internal
 
-import "context"
+import (
+	"context"
+	"sync/atomic"
+)
 
 type ctxKeyCorrelationId struct{}
 
+type ctxKeyStale struct{}
+
 func CtxWithCorrelationId(ctx context.Context, correlationId string) context.Context {
 	return context.WithValue(ctx, ctxKeyCorrelationId{}, correlationId)
 }
@@ -16,3 +21,25 @@ func CorrelationIdFromCtx(ctx context.Context) string {
 	}
 	return ""
 }
+
+// CtxWithStale returns a context that can be marked as stale, this
+// allows a caller to determine if a response was served from a stale
+// cache entry
+func CtxWithStale(ctx context.Context) context.Context {
+	return context.WithValue(ctx, ctxKeyStale{}, &atomic.Bool{})
+}
+
+// SetStaleCtx will mark the context as stale if it was created
+// with CtxWithStale
+func SetStaleCtx(ctx context.Context) {
+	if stale, ok := ctx.Value(ctxKeyStale{}).(*atomic.Bool); ok {
+		stale.Store(true)
+	}
+}
+
+func StaleFromCtx(ctx context.Context) bool {
+	if stale, ok := ctx.Value(ctxKeyStale{}).(*atomic.Bool); ok {
+		return stale.Load()
+	}
+	return false
+}
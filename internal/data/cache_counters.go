@@ -1,6 +1,8 @@
 package data
 
 type CacheCounters struct {
-	CounterHits   map[string]int `json:"counter_hits,omitempty"`
-	CounterMisses map[string]int `json:"counter_misses,omitempty"`
+	CounterHits      map[string]int `json:"counter_hits,omitempty"`
+	CounterMisses    map[string]int `json:"counter_misses,omitempty"`
+	CounterCoalesced map[string]int `json:"counter_coalesced,omitempty"`
+	CounterStale     map[string]int `json:"counter_stale,omitempty"`
 }
@@ -0,0 +1,34 @@
+package data
+
+import "encoding/json"
+
+const (
+	CacheEntryTypeEmployee       string = "employee"
+	CacheEntryTypeEmployeeSearch string = "employee_search"
+	CacheEntryTypeSleep          string = "sleep"
+)
+
+// CacheKey describes a single cached entry, the age and ttl are in
+// milliseconds (-1 if unknown); the ttl is how long until the entry
+// is removed from the cache
+type CacheKey struct {
+	EntryType string `json:"entry_type"`
+	Key       string `json:"key"`
+	CachedAt  int64  `json:"cached_at,omitempty"`
+	Age       int64  `json:"age"`
+	TTL       int64  `json:"ttl"`
+	Stale     bool   `json:"stale,omitempty"`
+}
+
+type CacheKeys struct {
+	Keys []CacheKey `json:"keys"`
+}
+
+// CacheEntry is a single cached entry, the value is the entry
+// as stored by the cache (as json) and the format is the format
+// the value is stored in (if known)
+type CacheEntry struct {
+	CacheKey
+	Format string          `json:"format,omitempty"`
+	Value  json.RawMessage `json:"value,omitempty"`
+}
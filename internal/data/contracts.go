@@ -7,13 +7,27 @@ const (
 	RouteEmployeesEmpNof string = RouteEmployees + "/%d"
 	RouteCacheCounters   string = "/cachecounters"
 	RouteCache           string = "/cache"
+	RouteCacheKeys       string = RouteCache + "/keys"
+	RouteCacheEntry      string = RouteCache + "/{" + PathEntryType + "}/{" + PathKey + "}"
+	RouteCacheEntryf     string = RouteCache + "/%s/%s"
 	RouteTimers          string = "/timers"
 	RouteSleep           string = "/sleep"
+	RouteReady           string = "/ready"
 )
 
-const PathEmpNo string = "EmpNo"
+const (
+	PathEmpNo     string = "EmpNo"
+	PathEntryType string = "EntryType"
+	PathKey       string = "Key"
+)
 
-const ParameterEmpNos string = "emp_nos"
+const (
+	ParameterEmpNos     string = "emp_nos"
+	ParameterFirstNames string = "first_names"
+	ParameterLastNames  string = "last_names"
+	ParameterGender     string = "gender"
+	ParameterEntryType  string = "entry_type"
+)
 
 type Request struct {
 	EmployeePartial EmployeePartial `json:"employee_partial"`
@@ -22,4 +36,5 @@ type Request struct {
 type Response struct {
 	Employee  *Employee   `json:"employee,omitempty"`
 	Employees []*Employee `json:"employees,omitempty"`
+	Stale     bool        `json:"stale,omitempty"`
 }
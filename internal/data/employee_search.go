@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
 )
@@ -26,6 +27,15 @@ func (e *EmployeeSearch) ToParams() url.Values {
 		}
 		params[ParameterEmpNos] = append(params[ParameterEmpNos], strings.Join(empNos, ","))
 	}
+	if len(e.FirstNames) > 0 {
+		params[ParameterFirstNames] = append(params[ParameterFirstNames], strings.Join(e.FirstNames, ","))
+	}
+	if len(e.LastNames) > 0 {
+		params[ParameterLastNames] = append(params[ParameterLastNames], strings.Join(e.LastNames, ","))
+	}
+	if e.Gender != "" {
+		params[ParameterGender] = append(params[ParameterGender], e.Gender)
+	}
 	return params
 }
 
@@ -39,10 +49,49 @@ func (e *EmployeeSearch) FromParams(params url.Values) {
 					e.EmpNos = append(e.EmpNos, empNo)
 				}
 			}
+		case ParameterFirstNames:
+			for _, value := range value {
+				e.FirstNames = append(e.FirstNames, strings.Split(value, ",")...)
+			}
+		case ParameterLastNames:
+			for _, value := range value {
+				e.LastNames = append(e.LastNames, strings.Split(value, ",")...)
+			}
+		case ParameterGender:
+			if len(value) > 0 {
+				e.Gender = value[0]
+			}
 		}
 	}
 }
 
+// Matches returns true if the employee would be included in the
+// results of the search; an empty search matches all employees
+func (e *EmployeeSearch) Matches(employee *Employee) bool {
+	//KIM: string comparisons are case insensitive to match the
+	// (default) collation of the database
+	equalFold := func(s string) func(string) bool {
+		return func(v string) bool { return strings.EqualFold(s, v) }
+	}
+
+	if employee == nil {
+		return false
+	}
+	if len(e.EmpNos) > 0 && !slices.Contains(e.EmpNos, employee.EmpNo) {
+		return false
+	}
+	if len(e.FirstNames) > 0 && !slices.ContainsFunc(e.FirstNames, equalFold(employee.FirstName)) {
+		return false
+	}
+	if len(e.LastNames) > 0 && !slices.ContainsFunc(e.LastNames, equalFold(employee.LastName)) {
+		return false
+	}
+	if e.Gender != "" && !strings.EqualFold(e.Gender, employee.Gender) {
+		return false
+	}
+	return true
+}
+
 func (e *EmployeeSearch) ToKey() (string, error) {
 	bytes, err := json.Marshal(e)
 	if err != nil {
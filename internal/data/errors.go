@@ -25,6 +25,8 @@ const (
 	ErrorTypeNotFound       ErrorType = "ERR_NOT_FOUND"
 	ErrorTypeNotCached      ErrorType = "ERR_NOT_CACHED"
 	ErrorTypeNotCachedRetry ErrorType = "ERR_NOT_CACHED_RETRY"
+	ErrorTypeStale          ErrorType = "ERR_STALE"
+	ErrorTypeRefresh        ErrorType = "ERR_REFRESH"
 )
 
 var (
@@ -102,6 +104,8 @@ var (
 	ErrNotFound       error = &Error{ErrorType: ErrorTypeNotFound}
 	ErrNotCached      error = &Error{ErrorType: ErrorTypeNotCached}
 	ErrNotCachedRetry error = &Error{ErrorType: ErrorTypeNotCachedRetry}
+	ErrStale          error = &Error{ErrorType: ErrorTypeStale}
+	ErrRefresh        error = &Error{ErrorType: ErrorTypeRefresh}
 )
 
 func NewUnknownError(item any) error {
@@ -119,3 +123,11 @@ func NewNotCachedError(item any) error {
 func NewNotCachedRetryError(item any) error {
 	return NewError(item, ErrorTypeNotCachedRetry)
 }
+
+func NewStaleError(item any) error {
+	return NewError(item, ErrorTypeStale)
+}
+
+func NewRefreshError(item any) error {
+	return NewError(item, ErrorTypeRefresh)
+}
@@ -88,22 +88,29 @@ func GetCaCert(caCertFile string) (*x509.CertPool, error) {
 	return caCertPool, nil
 }
 
+// GetTlsConfig returns a tls configuration; the certificate (and key) are
+// optional, without a ca cert file the system's root cas are used
 func GetTlsConfig(certFile, keyFile, caCertFile string) (*tls.Config, error) {
-	caCertPool, err := GetCaCert(caCertFile)
-	if err != nil {
-		return nil, err
-	}
-	certificate, err := GetCertificate(certFile, keyFile)
-	if err != nil {
-		return nil, err
-	}
-	return &tls.Config{
+	tlsConfig := &tls.Config{
 		// TLS versions below 1.2 are considered insecure
 		// see https://www.rfc-editor.org/rfc/rfc7525.txt for details
-		MinVersion:   tls.VersionTLS12,
-		RootCAs:      caCertPool,
-		Certificates: []tls.Certificate{certificate},
-	}, nil
+		MinVersion: tls.VersionTLS12,
+	}
+	if caCertFile != "" {
+		caCertPool, err := GetCaCert(caCertFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = caCertPool
+	}
+	if certFile != "" || keyFile != "" {
+		certificate, err := GetCertificate(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
 }
 
 func LaunchContext(wg *sync.WaitGroup, osSignal chan os.Signal) (context.Context, context.CancelFunc) {
@@ -0,0 +1,85 @@
+package logic
+
+import (
+	"context"
+	"sync"
+)
+
+type flight struct {
+	done    chan struct{}
+	waiters int
+	value   any
+	err     error
+}
+
+// flightGroup coalesces concurrent calls with the same key such that
+// only one call is executed and its result shared with all callers
+type flightGroup struct {
+	sync.Mutex
+	flights map[string]*flight
+}
+
+func newFlightGroup() *flightGroup {
+	return &flightGroup{
+		flights: make(map[string]*flight),
+	}
+}
+
+// do will execute fx if there's no call in flight for the given key,
+// otherwise it will wait for the call in flight; coalesced will be true
+// if the result was shared from another call. The call in flight isn't
+// cancelled with the ctx of the caller that started it, each caller
+// only stops waiting when its own ctx is done
+func (g *flightGroup) do(ctx context.Context, key string, fx func(context.Context) (any, error)) (value any, coalesced bool, err error) {
+	g.Lock()
+	f, coalesced := g.flights[key]
+	if !coalesced {
+		f = &flight{done: make(chan struct{})}
+		g.flights[key] = f
+		go func() {
+			defer func() {
+				g.Lock()
+				delete(g.flights, key)
+				g.Unlock()
+				close(f.done)
+			}()
+
+			//KIM: the shared call shouldn't be cancelled when the
+			// request that started it is cancelled, other callers
+			// may still be waiting on it
+			f.value, f.err = fx(context.WithoutCancel(ctx))
+		}()
+	}
+	f.waiters++
+	g.Unlock()
+	select {
+	case <-ctx.Done():
+		return nil, coalesced, ctx.Err()
+	case <-f.done:
+		return f.value, coalesced, f.err
+	}
+}
+
+// waiters returns the number of callers waiting on the call in flight
+// for the given key, including the caller that started it
+func (g *flightGroup) waiters(key string) int {
+	g.Lock()
+	defer g.Unlock()
+
+	if f, ok := g.flights[key]; ok {
+		return f.waiters
+	}
+	return 0
+}
+
+func coalesce[T any](ctx context.Context, g *flightGroup, key string, fx func(context.Context) (T, error)) (T, bool, error) {
+	var zero T
+
+	value, coalesced, err := g.do(ctx, key, func(ctx context.Context) (any, error) {
+		return fx(ctx)
+	})
+	if err != nil {
+		return zero, coalesced, err
+	}
+	return value.(T), coalesced, nil
+}
@@ -0,0 +1,75 @@
+package logic_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/antonio-alexander/go-blog-cache/internal/logic"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlightGroupLeaderCancelled(t *testing.T) {
+	const key string = "employee_1"
+
+	g := logic.NewFlightGroup()
+	ctxLeader, cancel := context.WithCancel(context.TODO())
+	defer cancel()
+	started, release := make(chan struct{}), make(chan struct{})
+
+	//start the leader, its call blocks until released
+	errLeader := make(chan error, 1)
+	go func() {
+		_, coalesced, err := g.Do(ctxLeader, key, func(ctx context.Context) (any, error) {
+			close(started)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-release:
+				return "employee", nil
+			}
+		})
+		assert.False(t, coalesced)
+		errLeader <- err
+	}()
+	<-started
+
+	//start the follower, it should wait on the leader's call
+	type result struct {
+		value     any
+		coalesced bool
+		err       error
+	}
+	results := make(chan result, 1)
+	go func() {
+		value, coalesced, err := g.Do(context.TODO(), key, func(ctx context.Context) (any, error) {
+			return nil, nil
+		})
+		results <- result{value, coalesced, err}
+	}()
+	assert.Eventually(t, func() bool {
+		return g.Waiters(key) == 2
+	}, time.Second, time.Millisecond)
+
+	//cancel the leader and validate that it stops waiting
+	cancel()
+	select {
+	case err := <-errLeader:
+		assert.ErrorIs(t, err, context.Canceled)
+	case <-time.After(time.Second):
+		assert.FailNow(t, "leader didn't return when cancelled")
+	}
+
+	//release the shared call and validate that the follower
+	// still gets the value
+	close(release)
+	select {
+	case r := <-results:
+		assert.Nil(t, r.err)
+		assert.True(t, r.coalesced)
+		assert.Equal(t, "employee", r.value)
+	case <-time.After(time.Second):
+		assert.FailNow(t, "follower didn't return")
+	}
+}
@@ -0,0 +1,26 @@
+package logic
+
+import "context"
+
+// FlightGroup exposes flightGroup to the logic_test package
+type FlightGroup struct {
+	*flightGroup
+}
+
+func NewFlightGroup() FlightGroup {
+	return FlightGroup{newFlightGroup()}
+}
+
+func (g FlightGroup) Do(ctx context.Context, key string, fx func(context.Context) (any, error)) (any, bool, error) {
+	return g.do(ctx, key, fx)
+}
+
+func (g FlightGroup) Waiters(key string) int {
+	return g.waiters(key)
+}
+
+// FlightWaiters returns the number of callers waiting on the read in
+// flight for the given key
+func FlightWaiters(l any, key string) int {
+	return l.(*logic).flightGroup.waiters(key)
+}
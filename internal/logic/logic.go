@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/antonio-alexander/go-blog-cache/internal"
 	"github.com/antonio-alexander/go-blog-cache/internal/cache"
@@ -32,12 +33,14 @@ type Logic interface {
 
 type logic struct {
 	sync.RWMutex
+	sync.WaitGroup
 	config struct {
 		cacheEnabled         bool
 		cacheRetryInterval   int
 		cacheMaxRetries      int
 		cacheRetryExpBackoff bool
 		cacheNotFoundEnabled bool
+		cacheCoalesceEnabled bool
 		mutateDisabled       bool
 	}
 	utilities.Logger
@@ -45,6 +48,8 @@ type logic struct {
 	cache               cache.Cache
 	sql                 sql.Sql
 	backoffRetryOptions []backoff.RetryOption
+	flightGroup         *flightGroup
+	refreshes           sync.Map //map[key]struct{}
 }
 
 func NewLogic(parameters ...any) interface {
@@ -52,7 +57,7 @@ func NewLogic(parameters ...any) interface {
 	internal.Opener
 	Logic
 } {
-	l := &logic{}
+	l := &logic{flightGroup: newFlightGroup()}
 	for _, parameter := range parameters {
 		switch v := parameter.(type) {
 		case sql.Sql:
@@ -102,6 +107,30 @@ func (l *logic) IncrementMiss(item any) (hitCount int) {
 	return l.Counter.IncrementMiss(key)
 }
 
+func (l *logic) IncrementStale(item any) (staleCount int) {
+	var key string
+
+	if l.Counter == nil {
+		return -1
+	}
+	switch v := item.(type) {
+	default:
+		return -1
+	case string:
+		key = fmt.Sprintf("employee_search_%s", v)
+	case int64:
+		key = fmt.Sprintf("employee_%d", v)
+	}
+	return l.Counter.IncrementStale(key)
+}
+
+func (l *logic) IncrementCoalesced(key string) (coalescedCount int) {
+	if l.Counter == nil {
+		return -1
+	}
+	return l.Counter.IncrementCoalesced(key)
+}
+
 func (l *logic) Configure(envs map[string]string) error {
 	l.Lock()
 	defer l.Unlock()
@@ -127,6 +156,9 @@ func (l *logic) Configure(envs map[string]string) error {
 	if cacheNotFoundEnabled, ok := envs["CACHE_NOT_FOUND_ENABLED"]; ok {
 		l.config.cacheNotFoundEnabled, _ = strconv.ParseBool(cacheNotFoundEnabled)
 	}
+	if cacheCoalesceEnabled, ok := envs["CACHE_ENABLE_COALESCE"]; ok {
+		l.config.cacheCoalesceEnabled, _ = strconv.ParseBool(cacheCoalesceEnabled)
+	}
 	return nil
 }
 
@@ -140,6 +172,9 @@ func (l *logic) Open(ctx context.Context) error {
 	if l.config.cacheEnabled {
 		l.Info(ctx, "cache enabled")
 	}
+	if l.config.cacheEnabled && l.config.cacheCoalesceEnabled {
+		l.Info(ctx, "cache coalesce enabled")
+	}
 	l.backoffRetryOptions = []backoff.RetryOption{
 		backoff.WithMaxTries(uint(l.config.cacheMaxRetries)),
 	}
@@ -151,31 +186,185 @@ func (l *logic) Open(ctx context.Context) error {
 }
 
 func (l *logic) Close(ctx context.Context) error {
+	l.Wait()
 	return nil
 }
 
+// leased returns true if the cache granted a lease to fill an entry, the
+// lease holder should fill the entry rather than wait for it to be filled
+func leased(ctx context.Context) bool {
+	_, ok := cache.LeaseFromCtx(ctx)
+	return ok
+}
+
+// fillWait determines how long to wait before a read that missed is
+// retried; if another read is filling the entry and the cache can notify
+// waiters, it will wait until the fill is written (for at most the retry
+// interval) and retry immediately, otherwise it will retry after the
+// retry interval
+func (l *logic) fillWait(ctx context.Context, err error, entryType, key string) error {
+	if !errors.Is(err, data.ErrNotCachedRetry) {
+		return backoff.RetryAfter(l.config.cacheRetryInterval)
+	}
+	ctxWait, cancel := context.WithTimeout(ctx,
+		time.Duration(l.config.cacheRetryInterval)*time.Second)
+	defer cancel()
+	switch err := cache.FillWait(ctxWait, l.cache, entryType, key); {
+	default:
+		l.Trace(ctx, "error while waiting on fill (%s: %s): %s", entryType, key, err)
+		return backoff.RetryAfter(l.config.cacheRetryInterval)
+	case err == nil, errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil:
+		return backoff.RetryAfter(0)
+	case ctx.Err() != nil:
+		return backoff.Permanent(ctx.Err())
+	case errors.Is(err, cache.ErrWaitUnsupported):
+		return backoff.RetryAfter(l.config.cacheRetryInterval)
+	}
+}
+
+// fillBegin attaches the cache's generation to the context before a fill
+// reads from the source, such that the fill's write is rejected if any
+// entry it writes is invalidated (e.g. by an update) in the meantime
+func (l *logic) fillBegin(ctx context.Context) context.Context {
+	if !l.config.cacheEnabled {
+		return ctx
+	}
+	ctx, err := cache.FillBegin(ctx, l.cache)
+	if err != nil {
+		l.Trace(ctx, "error while reading cache generation: %s", err)
+	}
+	return ctx
+}
+
+// invalidateSearches will evict any cached search that matches at least
+// one of the given employees (i.e. any search whose results may have
+// changed because the employee was created or updated)
+func (l *logic) invalidateSearches(ctx context.Context, employees ...*data.Employee) {
+	searches, err := l.cache.EmployeeSearchesRead(ctx)
+	if err != nil {
+		l.Trace(ctx, "error while reading employee searches from cache: %s", err)
+		return
+	}
+	var searchKeys []string
+	for searchKey, search := range searches {
+		for _, employee := range employees {
+			if search.Matches(employee) {
+				searchKeys = append(searchKeys, searchKey)
+				break
+			}
+		}
+	}
+	if len(searchKeys) <= 0 {
+		return
+	}
+	if err := l.cache.EmployeeSearchesDelete(ctx, searchKeys...); err != nil {
+		l.Trace(ctx, "error while deleting employee searches from cache: %s", err)
+		return
+	}
+	l.Trace(ctx, "cache invalidated (searches): %v", searchKeys)
+}
+
 func (l *logic) EmployeeCreate(ctx context.Context, employeePartial data.EmployeePartial) (*data.Employee, error) {
 	if l.config.mutateDisabled {
 		return nil, ErrMutationDisabled
 	}
-	return l.sql.EmployeeCreate(ctx, employeePartial)
+	employee, err := l.sql.EmployeeCreate(ctx, employeePartial)
+	if err != nil {
+		return nil, err
+	}
+	if l.config.cacheEnabled {
+		//KIM: the employee may have been cached as not found
+		if err := l.cache.EmployeesDelete(ctx, employee.EmpNo); err != nil {
+			l.Trace(ctx, "error while deleting employee (%d) from cache: %s", employee.EmpNo, err)
+		}
+		l.invalidateSearches(ctx, employee)
+	}
+	return employee, nil
+}
+
+// refresh will execute fx in the background to repopulate a stale
+// cache entry, only a single refresh for a given key will be executed
+// at a time
+func (l *logic) refresh(ctx context.Context, key string, fx func(context.Context) error) {
+	if _, refreshing := l.refreshes.LoadOrStore(key, struct{}{}); refreshing {
+		return
+	}
+	l.Add(1)
+	go func() {
+		defer l.Done()
+		defer l.refreshes.Delete(key)
+
+		//KIM: the refresh shouldn't be cancelled when the request
+		// that triggered it completes
+		ctx := context.WithoutCancel(ctx)
+		if err := fx(ctx); err != nil {
+			l.Trace(ctx, "error while refreshing (%s): %s", key, err)
+			return
+		}
+		l.Trace(ctx, "refreshed (%s)", key)
+	}()
+}
+
+type coalescedRead[T any] struct {
+	value T
+	stale bool
+}
+
+// coalesceRead will execute fx such that concurrent calls with the same key
+// within this process share a single execution (and result)
+func coalesceRead[T any](ctx context.Context, l *logic, key string, fx func(context.Context) (T, error)) (T, error) {
+	if !l.config.cacheEnabled || !l.config.cacheCoalesceEnabled {
+		return fx(ctx)
+	}
+	read, coalesced, err := coalesce(ctx, l.flightGroup, key,
+		func(ctx context.Context) (coalescedRead[T], error) {
+			ctx = internal.CtxWithStale(ctx)
+			value, err := fx(ctx)
+			return coalescedRead[T]{
+				value: value,
+				stale: internal.StaleFromCtx(ctx),
+			}, err
+		})
+	if coalesced {
+		l.Trace(ctx, "coalesced read (%s)", key)
+		l.IncrementCoalesced(key)
+	}
+	if read.stale {
+		internal.SetStaleCtx(ctx)
+	}
+	return read.value, err
 }
 
 func (l *logic) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee, error) {
+	return coalesceRead(ctx, l, fmt.Sprintf("employee_%d", empNo),
+		func(ctx context.Context) (*data.Employee, error) {
+			return l.employeeRead(ctx, empNo)
+		})
+}
+
+func (l *logic) employeeRead(ctx context.Context, empNo int64) (*data.Employee, error) {
 	if l.config.cacheEnabled {
+		//KIM: if the read misses, the cache may grant this read a lease
+		// to fill the entry; the lease is carried by the context so it's
+		// attached to the write
+		ctx = cache.CtxWithLease(ctx)
 		employee, err := backoff.Retry(ctx, func() (*data.Employee, error) {
 			employee, err := l.cache.EmployeeRead(ctx, empNo)
 			if err != nil {
 				switch {
 				default:
 					return nil, backoff.Permanent(err)
+				case leased(ctx):
+					return nil, backoff.Permanent(err)
 				case errors.Is(err, cache.ErrEmployeeNotCached),
 					errors.Is(err, cache.ErrEmployeeReadAlreadySet):
 					l.Trace(ctx, "cache miss (retry) for employee (%d): %s", empNo, err)
 					l.IncrementMiss(empNo)
-					return nil, backoff.RetryAfter(l.config.cacheRetryInterval)
+					return nil, l.fillWait(ctx, err, data.CacheEntryTypeEmployee, fmt.Sprint(empNo))
 				case errors.Is(err, cache.ErrEmployeeNotFoundCached):
 					return nil, backoff.Permanent(err)
+				case errors.Is(err, data.ErrStale), errors.Is(err, data.ErrRefresh):
+					return employee, backoff.Permanent(err)
 				}
 			}
 			return employee, nil
@@ -185,7 +374,34 @@ func (l *logic) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee,
 			l.IncrementHit(empNo)
 			return employee, nil
 		}
-		if l.config.cacheNotFoundEnabled &&
+		if (errors.Is(err, data.ErrStale) || errors.Is(err, data.ErrRefresh)) && employee != nil {
+			switch {
+			case errors.Is(err, data.ErrStale):
+				l.Trace(ctx, "cache hit (stale) for employee (%d)", empNo)
+				l.IncrementStale(empNo)
+				internal.SetStaleCtx(ctx)
+			default:
+				//KIM: an entry that's refreshed early is still within
+				// its ttl, so it's a hit rather than stale
+				l.Trace(ctx, "cache hit (early refresh) for employee (%d)", empNo)
+				l.IncrementHit(empNo)
+			}
+			l.refresh(ctx, fmt.Sprintf("employee_%d", empNo), func(ctx context.Context) error {
+				ctx = l.fillBegin(ctx)
+				tFetch := time.Now()
+				employee, err := l.sql.EmployeeRead(ctx, empNo)
+				if err != nil {
+					if errors.Is(err, data.ErrNotFound) {
+						return l.cache.EmployeesDelete(ctx, empNo)
+					}
+					return err
+				}
+				ctx = cache.CtxWithFetchDuration(ctx, time.Since(tFetch))
+				return l.cache.EmployeesWrite(ctx, data.EmployeeSearch{}, employee)
+			})
+			return employee, nil
+		}
+		if l.config.cacheNotFoundEnabled && !leased(ctx) &&
 			(errors.Is(err, data.ErrNotCached) ||
 				errors.Is(err, data.ErrNotCachedRetry)) {
 			l.Trace(ctx, "cache hit (not found) for employee (%d)", empNo)
@@ -195,6 +411,8 @@ func (l *logic) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee,
 		l.Trace(ctx, "cache miss (not found) for employee (%d)", empNo)
 		l.IncrementMiss(empNo)
 	}
+	ctx = l.fillBegin(ctx)
+	tFetch := time.Now()
 	employee, err := l.sql.EmployeeRead(ctx, empNo)
 	if err != nil {
 		if l.config.cacheEnabled && errors.Is(err, data.ErrNotFound) {
@@ -206,6 +424,7 @@ func (l *logic) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee,
 		return nil, err
 	}
 	if l.config.cacheEnabled {
+		ctx = cache.CtxWithFetchDuration(ctx, time.Since(tFetch))
 		if err := l.cache.EmployeesWrite(ctx, data.EmployeeSearch{}, employee); err != nil {
 			l.Trace(ctx, "error while writing employee (%d) to cache: %s", empNo, err)
 		}
@@ -214,6 +433,20 @@ func (l *logic) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee,
 }
 
 func (l *logic) EmployeesSearch(ctx context.Context, search data.EmployeeSearch) ([]*data.Employee, error) {
+	if !l.config.cacheEnabled || !l.config.cacheCoalesceEnabled {
+		return l.employeesSearch(ctx, search)
+	}
+	searchKey, err := search.ToKey()
+	if err != nil {
+		return nil, err
+	}
+	return coalesceRead(ctx, l, fmt.Sprintf("employee_search_%s", searchKey),
+		func(ctx context.Context) ([]*data.Employee, error) {
+			return l.employeesSearch(ctx, search)
+		})
+}
+
+func (l *logic) employeesSearch(ctx context.Context, search data.EmployeeSearch) ([]*data.Employee, error) {
 	var searchKey string
 	var err error
 
@@ -222,20 +455,25 @@ func (l *logic) EmployeesSearch(ctx context.Context, search data.EmployeeSearch)
 		if err != nil {
 			return nil, err
 		}
+		ctx = cache.CtxWithLease(ctx)
 		employees, err := backoff.Retry(ctx, func() ([]*data.Employee, error) {
 			employees, err := l.cache.EmployeesRead(ctx, search)
 			if err != nil {
 				switch {
 				default:
 					return nil, backoff.Permanent(err)
+				case leased(ctx):
+					return nil, backoff.Permanent(err)
 				case errors.Is(err, cache.ErrEmployeeNotCached),
 					errors.Is(err, cache.ErrEmployeeReadAlreadySet):
 					l.Trace(ctx, "search cache miss (retry): %s", err)
-					return nil, backoff.RetryAfter(l.config.cacheRetryInterval)
+					return nil, l.fillWait(ctx, err, data.CacheEntryTypeEmployeeSearch, searchKey)
 				case errors.Is(err, cache.ErrEmployeeNotFoundCached):
 					l.Trace(ctx, "cache hit for employee  search (%s) read cache hit (not found)", searchKey)
 					l.IncrementHit(searchKey)
 					return nil, backoff.Permanent(err)
+				case errors.Is(err, data.ErrStale), errors.Is(err, data.ErrRefresh):
+					return employees, backoff.Permanent(err)
 				}
 			}
 			return employees, nil
@@ -245,7 +483,29 @@ func (l *logic) EmployeesSearch(ctx context.Context, search data.EmployeeSearch)
 			l.IncrementHit(searchKey)
 			return employees, nil
 		}
-		if l.config.cacheNotFoundEnabled &&
+		if (errors.Is(err, data.ErrStale) || errors.Is(err, data.ErrRefresh)) && employees != nil {
+			switch {
+			case errors.Is(err, data.ErrStale):
+				l.Trace(ctx, "cache hit (stale) for employee search (%s)", searchKey)
+				l.IncrementStale(searchKey)
+				internal.SetStaleCtx(ctx)
+			default:
+				l.Trace(ctx, "cache hit (early refresh) for employee search (%s)", searchKey)
+				l.IncrementHit(searchKey)
+			}
+			l.refresh(ctx, fmt.Sprintf("employee_search_%s", searchKey), func(ctx context.Context) error {
+				ctx = l.fillBegin(ctx)
+				tFetch := time.Now()
+				employees, err := l.sql.EmployeesSearch(ctx, search)
+				if err != nil {
+					return err
+				}
+				ctx = cache.CtxWithFetchDuration(ctx, time.Since(tFetch))
+				return l.cache.EmployeesWrite(ctx, search, employees...)
+			})
+			return employees, nil
+		}
+		if l.config.cacheNotFoundEnabled && !leased(ctx) &&
 			(errors.Is(err, data.ErrNotCached) ||
 				errors.Is(err, data.ErrNotCachedRetry)) {
 			l.Trace(ctx, "cache hit (not found) for employee search (%s)", searchKey)
@@ -255,6 +515,8 @@ func (l *logic) EmployeesSearch(ctx context.Context, search data.EmployeeSearch)
 		l.Trace(ctx, "cache miss (not found) for employee search (%s)", searchKey)
 		l.IncrementMiss(searchKey)
 	}
+	ctx = l.fillBegin(ctx)
+	tFetch := time.Now()
 	employees, err := l.sql.EmployeesSearch(ctx, search)
 	if err != nil {
 		if l.config.cacheEnabled && errors.Is(err, data.ErrNotFound) {
@@ -265,6 +527,7 @@ func (l *logic) EmployeesSearch(ctx context.Context, search data.EmployeeSearch)
 		return nil, err
 	}
 	if l.config.cacheEnabled {
+		ctx = cache.CtxWithFetchDuration(ctx, time.Since(tFetch))
 		if err := l.cache.EmployeesWrite(ctx, search, employees...); err != nil {
 			l.Trace(ctx, "error while writing employees (%s) to cache: %s", searchKey, err)
 		}
@@ -276,7 +539,9 @@ func (l *logic) EmployeeUpdate(ctx context.Context, empNo int64, employeePartial
 	if l.config.mutateDisabled {
 		return nil, ErrMutationDisabled
 	}
-	employee, err := l.sql.EmployeeUpdate(ctx, empNo, employeePartial)
+	//KIM: the employee (before it's updated) is needed to determine
+	// which searches it will stop matching
+	employee, employeeOld, err := l.sql.EmployeeUpdate(ctx, empNo, employeePartial)
 	if err != nil {
 		return nil, err
 	}
@@ -286,6 +551,7 @@ func (l *logic) EmployeeUpdate(ctx context.Context, empNo int64, employeePartial
 		} else {
 			l.Trace(ctx, "cache invalidated: %d", empNo)
 		}
+		l.invalidateSearches(ctx, employeeOld, employee)
 	}
 	return employee, nil
 }
@@ -306,20 +572,32 @@ func (l *logic) EmployeeDelete(ctx context.Context, empNo int64) error {
 }
 
 func (l *logic) Sleep(ctx context.Context, s data.Sleep) (*data.Sleep, error) {
+	return coalesceRead(ctx, l, fmt.Sprintf("sleep_%s", s.Id),
+		func(ctx context.Context) (*data.Sleep, error) {
+			return l.sleep(ctx, s)
+		})
+}
+
+func (l *logic) sleep(ctx context.Context, s data.Sleep) (*data.Sleep, error) {
 	if l.config.cacheEnabled {
+		ctx = cache.CtxWithLease(ctx)
 		sleep, err := backoff.Retry(ctx, func() (*data.Sleep, error) {
 			sleep, err := l.cache.SleepRead(ctx, s.Id)
 			if err != nil {
 				switch {
 				default:
 					return nil, backoff.Permanent(err)
+				case leased(ctx):
+					return nil, backoff.Permanent(err)
 				case errors.Is(err, cache.ErrEmployeeNotCached),
 					errors.Is(err, cache.ErrEmployeeReadAlreadySet):
 					l.Trace(ctx, "cache miss (retry) for sleep (%s): %s", s.Id, err)
 					l.IncrementMiss(s.Id)
-					return nil, backoff.RetryAfter(l.config.cacheRetryInterval)
+					return nil, l.fillWait(ctx, err, data.CacheEntryTypeSleep, s.Id)
 				case errors.Is(err, cache.ErrEmployeeNotFoundCached):
 					return nil, backoff.Permanent(err)
+				case errors.Is(err, data.ErrStale), errors.Is(err, data.ErrRefresh):
+					return sleep, backoff.Permanent(err)
 				}
 			}
 			return sleep, nil
@@ -329,7 +607,28 @@ func (l *logic) Sleep(ctx context.Context, s data.Sleep) (*data.Sleep, error) {
 			l.IncrementHit(s.Id)
 			return sleep, nil
 		}
-		if l.config.cacheNotFoundEnabled &&
+		if (errors.Is(err, data.ErrStale) || errors.Is(err, data.ErrRefresh)) && sleep != nil {
+			switch {
+			case errors.Is(err, data.ErrStale):
+				l.Trace(ctx, "cache hit (stale) for sleep (%s)", s.Id)
+				l.IncrementStale(s.Id)
+				internal.SetStaleCtx(ctx)
+			default:
+				l.Trace(ctx, "cache hit (early refresh) for sleep (%s)", s.Id)
+				l.IncrementHit(s.Id)
+			}
+			l.refresh(ctx, fmt.Sprintf("sleep_%s", s.Id), func(ctx context.Context) error {
+				ctx = l.fillBegin(ctx)
+				tFetch := time.Now()
+				if _, err := l.sql.Sleep(ctx, s); err != nil {
+					return err
+				}
+				ctx = cache.CtxWithFetchDuration(ctx, time.Since(tFetch))
+				return l.cache.SleepWrite(ctx, &s)
+			})
+			return sleep, nil
+		}
+		if l.config.cacheNotFoundEnabled && !leased(ctx) &&
 			(errors.Is(err, data.ErrNotCached) ||
 				errors.Is(err, data.ErrNotCachedRetry)) {
 			l.Trace(ctx, "cache hit (not found) for sleep (%s)", s.Id)
@@ -339,11 +638,14 @@ func (l *logic) Sleep(ctx context.Context, s data.Sleep) (*data.Sleep, error) {
 		l.Trace(ctx, "cache miss (not found) for sleep (%s)", s.Id)
 		l.IncrementMiss(s.Id)
 	}
+	ctx = l.fillBegin(ctx)
+	tFetch := time.Now()
 	sleep, err := l.sql.Sleep(ctx, s)
 	if err != nil {
 		return nil, err
 	}
 	if l.config.cacheEnabled {
+		ctx = cache.CtxWithFetchDuration(ctx, time.Since(tFetch))
 		if err := l.cache.SleepWrite(ctx, &s); err != nil {
 			l.Trace(ctx, "error while writing sleep (%s) to cache: %s", s.Id, err)
 		}
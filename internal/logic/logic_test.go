@@ -2,10 +2,12 @@ package logic_test
 
 import (
 	"context"
+	"fmt"
 	"os"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -14,6 +16,7 @@ import (
 	"github.com/antonio-alexander/go-blog-cache/internal/data"
 	"github.com/antonio-alexander/go-blog-cache/internal/logic"
 	"github.com/antonio-alexander/go-blog-cache/internal/sql"
+	"github.com/antonio-alexander/go-blog-cache/internal/utilities"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -74,6 +77,21 @@ type logicTest struct {
 	logic.Logic
 }
 
+// sqlCounter counts the employee reads made to sql
+type sqlCounter struct {
+	sql.Sql
+	employeeReads      atomic.Int64
+	beforeEmployeeRead func()
+}
+
+func (s *sqlCounter) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee, error) {
+	s.employeeReads.Add(1)
+	if s.beforeEmployeeRead != nil {
+		s.beforeEmployeeRead()
+	}
+	return s.Sql.EmployeeRead(ctx, empNo)
+}
+
 func newLogicTest(cacheType string) *logicTest {
 	var c interface {
 		internal.Opener
@@ -332,6 +350,70 @@ func (l *logicTest) TestLogicConcurrent(cacheEnabled bool) func(t *testing.T) {
 	}
 }
 
+func (l *logicTest) TestLogicSearchInvalidation(cacheEnabled bool) func(t *testing.T) {
+	return func(t *testing.T) {
+		if !cacheEnabled {
+			t.Skip("cache disabled")
+		}
+
+		// generate context
+		ctx := context.TODO()
+
+		// create employee
+		birthDate, hireDate := time.Now().Unix(), time.Now().Unix()
+		firstName := internal.GenerateId()[:14]
+		lastName := internal.GenerateId()[:16]
+		gender := "M"
+		employeeCreated, err := l.EmployeeCreate(ctx, data.EmployeePartial{
+			BirthDate: &birthDate,
+			FirstName: &firstName,
+			LastName:  &lastName,
+			HireDate:  &hireDate,
+			Gender:    &gender,
+		})
+		assert.Nil(t, err)
+		assert.NotNil(t, employeeCreated)
+		empNo := employeeCreated.EmpNo
+		defer func(empNo int64) {
+			_ = l.EmployeeDelete(ctx, empNo)
+		}(empNo)
+
+		// search (and cache) by last name, the employee with the
+		// updated last name doesn't exist yet
+		updatedLastName := internal.GenerateId()[:16]
+		search := data.EmployeeSearch{LastNames: []string{lastName}}
+		searchUpdated := data.EmployeeSearch{LastNames: []string{updatedLastName}}
+		employees, err := l.EmployeesSearch(ctx, search)
+		assert.Nil(t, err)
+		assert.Len(t, employees, 1)
+		_, err = l.EmployeesSearch(ctx, searchUpdated)
+		assert.NotNil(t, err)
+		searchKey, _ := search.ToKey()
+		searches, err := l.cache.EmployeeSearchesRead(ctx)
+		assert.Nil(t, err)
+		assert.Contains(t, searches, searchKey)
+
+		// update the employee's last name and validate that the employee
+		// is no longer found in the original search, but is found in
+		// the updated search
+		employeeUpdated, err := l.EmployeeUpdate(ctx, empNo, data.EmployeePartial{
+			LastName: &updatedLastName,
+		})
+		assert.Nil(t, err)
+		assert.NotNil(t, employeeUpdated)
+		searches, err = l.cache.EmployeeSearchesRead(ctx)
+		assert.Nil(t, err)
+		assert.NotContains(t, searches, searchKey)
+		_, err = l.EmployeesSearch(ctx, search)
+		assert.NotNil(t, err)
+		employees, err = l.EmployeesSearch(ctx, searchUpdated)
+		assert.Nil(t, err)
+		if assert.Len(t, employees, 1) {
+			assert.Equal(t, employeeUpdated, employees[0])
+		}
+	}
+}
+
 func testLogic(t *testing.T, cacheType string) {
 	c := newLogicTest(cacheType)
 
@@ -352,6 +434,7 @@ func testLogic(t *testing.T, cacheType string) {
 	}()
 	t.Run("Logic", c.TestLogic(cacheEnabled))
 	t.Run("Concurrent Mutate", c.TestLogicConcurrent(cacheEnabled))
+	t.Run("Search Invalidation", c.TestLogicSearchInvalidation(cacheEnabled))
 }
 
 func TestLogicMemory(t *testing.T) {
@@ -361,3 +444,164 @@ func TestLogicMemory(t *testing.T) {
 func TestLogicRedis(t *testing.T) {
 	testLogic(t, "redis")
 }
+
+func TestLogicCoalesce(t *testing.T) {
+	const nGoRoutines int = 10
+
+	var wg sync.WaitGroup
+
+	ctx := context.TODO()
+	logger, counter := utilities.NewLogger(), utilities.NewCounter()
+	s, c := sql.NewMySql(logger), cache.NewMemory(logger)
+	sqlCounted := &sqlCounter{Sql: s}
+	l := logic.NewLogic(sqlCounted, c, logger, counter)
+	logicEnvs := make(map[string]string)
+	for key, value := range envs {
+		logicEnvs[key] = value
+	}
+	logicEnvs["LOGIC_CACHE_ENABLED"] = "true"
+	logicEnvs["CACHE_ENABLE_COALESCE"] = "true"
+	for _, item := range []interface {
+		internal.Configurer
+		internal.Opener
+	}{s, c, l} {
+		err := item.Configure(logicEnvs)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to configure")
+		}
+		err = item.Open(ctx)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to open")
+		}
+		defer func(item internal.Opener) {
+			if err := item.Close(ctx); err != nil {
+				t.Logf("error while closing: %s", err)
+			}
+		}(item)
+	}
+
+	//create employee
+	birthDate, hireDate := time.Now().Unix(), time.Now().Unix()
+	firstName := internal.GenerateId()[:14]
+	lastName := internal.GenerateId()[:16]
+	gender := "M"
+	employeeCreated, err := l.EmployeeCreate(ctx, data.EmployeePartial{
+		BirthDate: &birthDate,
+		FirstName: &firstName,
+		LastName:  &lastName,
+		HireDate:  &hireDate,
+		Gender:    &gender,
+	})
+	if !assert.Nil(t, err) {
+		assert.FailNow(t, "unable to create employee")
+	}
+	empNo := employeeCreated.EmpNo
+	defer func(empNo int64) {
+		_ = l.EmployeeDelete(ctx, empNo)
+	}(empNo)
+
+	//read the employee concurrently, the sql read is held until every
+	// caller is waiting on it; validate that every caller gets the same
+	// employee from a single sql read
+	key := fmt.Sprintf("employee_%d", empNo)
+	sqlCounted.beforeEmployeeRead = func() {
+		assert.Eventually(t, func() bool {
+			return logic.FlightWaiters(l, key) == nGoRoutines
+		}, 5*time.Second, time.Millisecond)
+	}
+	start := make(chan struct{})
+	employeesRead := make([]*data.Employee, nGoRoutines)
+	for i := range nGoRoutines {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			<-start
+			employee, err := l.EmployeeRead(ctx, empNo)
+			assert.Nil(t, err)
+			employeesRead[i] = employee
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	for _, employeeRead := range employeesRead {
+		assert.Equal(t, employeeCreated, employeeRead)
+	}
+	assert.Equal(t, int64(1), sqlCounted.employeeReads.Load())
+	assert.Equal(t, nGoRoutines-1, counter.ReadAll().CounterCoalesced[key])
+}
+
+func TestLogicWarmup(t *testing.T) {
+	ctx := context.TODO()
+	logger := utilities.NewLogger()
+	s, c := sql.NewMySql(logger), cache.NewMemory(logger)
+	for _, item := range []interface {
+		internal.Configurer
+		internal.Opener
+	}{s, c} {
+		err := item.Configure(envs)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to configure")
+		}
+		err = item.Open(ctx)
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to open")
+		}
+		defer func(item internal.Opener) {
+			if err := item.Close(ctx); err != nil {
+				t.Logf("error while closing: %s", err)
+			}
+		}(item)
+	}
+
+	// create employees, the second employee is the most recently hired
+	var empNos []int64
+	for _, hireDate := range []int64{time.Now().Add(-time.Hour).Unix(), time.Now().Unix()} {
+		birthDate := time.Now().Unix()
+		firstName := internal.GenerateId()[:14]
+		lastName := internal.GenerateId()[:16]
+		gender := "M"
+		employeeCreated, err := s.EmployeeCreate(ctx, data.EmployeePartial{
+			BirthDate: &birthDate,
+			FirstName: &firstName,
+			LastName:  &lastName,
+			HireDate:  &hireDate,
+			Gender:    &gender,
+		})
+		if !assert.Nil(t, err) {
+			assert.FailNow(t, "unable to create employee")
+		}
+		empNos = append(empNos, employeeCreated.EmpNo)
+		defer func(empNo int64) {
+			_ = s.EmployeeDelete(ctx, empNo)
+		}(employeeCreated.EmpNo)
+	}
+
+	// warm up the cache with the first employee (by emp_no) and
+	// the second employee (most recently hired)
+	w := logic.NewWarmer(s, c, logger)
+	err := w.Configure(map[string]string{
+		"CACHE_WARMUP_ENABLED":        "true",
+		"CACHE_WARMUP_EMP_NOS":        fmt.Sprint(empNos[0]),
+		"CACHE_WARMUP_RECENTLY_HIRED": "1",
+		"CACHE_WARMUP_RATE":           "10",
+	})
+	assert.Nil(t, err)
+	err = w.Open(ctx)
+	if !assert.Nil(t, err) {
+		assert.FailNow(t, "unable to open warmer")
+	}
+	defer func() {
+		if err := w.Close(ctx); err != nil {
+			t.Logf("error while closing warmer: %s", err)
+		}
+	}()
+	assert.Eventually(t, w.Ready, 10*time.Second, 100*time.Millisecond)
+
+	// validate that both employees were cached
+	for _, empNo := range empNos {
+		employee, err := c.EmployeeRead(ctx, empNo)
+		assert.Nil(t, err)
+		assert.NotNil(t, employee)
+	}
+}
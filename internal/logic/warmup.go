@@ -0,0 +1,229 @@
+package logic
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/antonio-alexander/go-blog-cache/internal"
+	"github.com/antonio-alexander/go-blog-cache/internal/cache"
+	"github.com/antonio-alexander/go-blog-cache/internal/data"
+	"github.com/antonio-alexander/go-blog-cache/internal/sql"
+	"github.com/antonio-alexander/go-blog-cache/internal/utilities"
+)
+
+type warmer struct {
+	sync.WaitGroup
+	config struct {
+		enabled        bool
+		empNos         []int64
+		file           string
+		recentlyHired  int
+		rate           int
+		batchSize      int
+		progressPeriod time.Duration
+	}
+	utilities.Logger
+	cache     cache.Cache
+	sql       sql.Sql
+	ready     atomic.Bool
+	ctx       context.Context
+	ctxCancel context.CancelFunc
+}
+
+// NewWarmer creates a warmer that will preload employees into the cache
+// from a list of emp_nos, a file of emp_nos and/or the most recently
+// hired employees; the warmer isn't ready until the warm up is complete
+func NewWarmer(parameters ...any) interface {
+	internal.Configurer
+	internal.Opener
+	internal.Readier
+} {
+	w := &warmer{}
+	for _, parameter := range parameters {
+		switch v := parameter.(type) {
+		case sql.Sql:
+			w.sql = v
+		case cache.Cache:
+			w.cache = v
+		case utilities.Logger:
+			w.Logger = v
+		}
+	}
+	return w
+}
+
+// parseEmpNos will parse emp_nos separated by commas and/or whitespace
+func parseEmpNos(s string) ([]int64, error) {
+	var empNos []int64
+
+	for _, field := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t' || r == '\n' || r == '\r'
+	}) {
+		empNo, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		empNos = append(empNos, empNo)
+	}
+	return empNos, nil
+}
+
+func (w *warmer) Configure(envs map[string]string) error {
+	w.config.batchSize = 100
+	w.config.progressPeriod = 5 * time.Second
+	if s, ok := envs["CACHE_WARMUP_ENABLED"]; ok {
+		w.config.enabled, _ = strconv.ParseBool(s)
+	}
+	if s, ok := envs["CACHE_WARMUP_EMP_NOS"]; ok {
+		empNos, err := parseEmpNos(s)
+		if err != nil {
+			return err
+		}
+		w.config.empNos = empNos
+	}
+	if s, ok := envs["CACHE_WARMUP_FILE"]; ok {
+		w.config.file = s
+	}
+	if s, ok := envs["CACHE_WARMUP_RECENTLY_HIRED"]; ok {
+		w.config.recentlyHired, _ = strconv.Atoi(s)
+	}
+	if s, ok := envs["CACHE_WARMUP_RATE"]; ok {
+		w.config.rate, _ = strconv.Atoi(s)
+	}
+	if s, ok := envs["CACHE_WARMUP_BATCH_SIZE"]; ok {
+		if batchSize, _ := strconv.Atoi(s); batchSize > 0 {
+			w.config.batchSize = batchSize
+		}
+	}
+	return nil
+}
+
+func (w *warmer) Open(ctx context.Context) error {
+	if !w.config.enabled {
+		w.ready.Store(true)
+		return nil
+	}
+	if w.cache == nil || w.sql == nil {
+		return errors.New("warm up enabled, but no cache or sql set/configured")
+	}
+	empNos := w.config.empNos
+	if w.config.file != "" {
+		bytes, err := os.ReadFile(w.config.file)
+		if err != nil {
+			return err
+		}
+		fileEmpNos, err := parseEmpNos(string(bytes))
+		if err != nil {
+			return err
+		}
+		empNos = append(empNos, fileEmpNos...)
+	}
+	w.ctx, w.ctxCancel = context.WithCancel(context.Background())
+	w.launchWarmup(empNos)
+	w.Info(ctx, "cache: warm up started")
+	return nil
+}
+
+func (w *warmer) Close(ctx context.Context) error {
+	if w.ctxCancel != nil {
+		w.ctxCancel()
+	}
+	w.Wait()
+	return nil
+}
+
+// Ready returns true once the warm up has completed (successfully
+// or not) or if warm up is disabled
+func (w *warmer) Ready() bool {
+	return w.ready.Load()
+}
+
+func (w *warmer) launchWarmup(empNos []int64) {
+	started := make(chan struct{})
+	w.Add(1)
+	go func() {
+		defer w.Done()
+		defer w.ready.Store(true)
+
+		var nWarmed, nTotal int
+		var tRate <-chan time.Time
+
+		if w.config.rate > 0 {
+			ticker := time.NewTicker(time.Second / time.Duration(w.config.rate))
+			defer ticker.Stop()
+			tRate = ticker.C
+		}
+		tProgress := time.NewTicker(w.config.progressPeriod)
+		defer tProgress.Stop()
+		tStart := time.Now()
+		close(started)
+
+		//KIM: the cache writes are rate limited rather than the sql
+		// reads since the reads are batched
+		//KIM: each batch is a fill, so any employee updated while the
+		// batch is being read won't be overwritten by the warm up
+		fillBeginFx := func(ctx context.Context) context.Context {
+			ctx, err := cache.FillBegin(ctx, w.cache)
+			if err != nil {
+				w.Error(ctx, "error while reading cache generation: %s", err)
+			}
+			return ctx
+		}
+		writeFx := func(ctx context.Context, employees ...*data.Employee) bool {
+			for _, employee := range employees {
+				if tRate != nil {
+					select {
+					case <-w.ctx.Done():
+						return false
+					case <-tRate:
+					}
+				}
+				select {
+				default:
+				case <-w.ctx.Done():
+					return false
+				case <-tProgress.C:
+					w.Info(w.ctx, "cache: warm up progress (%d/%d)", nWarmed, nTotal)
+				}
+				if err := w.cache.EmployeesWrite(ctx, data.EmployeeSearch{}, employee); err != nil {
+					w.Error(w.ctx, "error while warming up employee (%d): %s", employee.EmpNo, err)
+					continue
+				}
+				nWarmed++
+			}
+			return true
+		}
+		nTotal = len(empNos) + w.config.recentlyHired
+		for i := 0; i < len(empNos); i += w.config.batchSize {
+			batch := empNos[i:min(i+w.config.batchSize, len(empNos))]
+			ctx := fillBeginFx(w.ctx)
+			employees, err := w.sql.EmployeesSearch(ctx, data.EmployeeSearch{EmpNos: batch})
+			if err != nil && !errors.Is(err, data.ErrNotFound) {
+				w.Error(w.ctx, "error while reading employees to warm up: %s", err)
+				continue
+			}
+			if !writeFx(ctx, employees...) {
+				return
+			}
+		}
+		if w.config.recentlyHired > 0 {
+			ctx := fillBeginFx(w.ctx)
+			employees, err := w.sql.EmployeesRecentlyHired(ctx, w.config.recentlyHired)
+			if err != nil {
+				w.Error(w.ctx, "error while reading recently hired employees to warm up: %s", err)
+			}
+			if !writeFx(ctx, employees...) {
+				return
+			}
+		}
+		w.Info(w.ctx, "cache: warm up complete (%d/%d) in %v", nWarmed, nTotal,
+			time.Since(tStart))
+	}()
+	<-started
+}
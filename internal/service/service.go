@@ -56,9 +56,11 @@ type service struct {
 		corsDebug        bool
 		timersEnabled    bool
 	}
-	ctx    context.Context
-	cancel context.CancelFunc
-	cache  internal.Clearer
+	ctx       context.Context
+	cancel    context.CancelFunc
+	cache     internal.Clearer
+	inspector cache.Inspector
+	readiers  []internal.Readier
 	utilities.Logger
 	utilities.Counter
 	utilities.Timers
@@ -85,6 +87,7 @@ func NewService(parameters ...any) interface {
 			internal.Clearer
 		}:
 			s.cache = p
+			s.inspector, _ = p.(cache.Inspector)
 		case logic.Logic:
 			s.Logic = p
 		case utilities.Counter:
@@ -93,6 +96,8 @@ func NewService(parameters ...any) interface {
 			s.Timers = p
 		case utilities.Logger:
 			s.Logger = p
+		case internal.Readier:
+			s.readiers = append(s.readiers, p)
 		}
 	}
 	return s
@@ -195,6 +200,7 @@ func (s *service) endpointEmployeeRead(writer http.ResponseWriter, request *http
 		_ = handleResponse(writer, err, nil)
 		return
 	}
+	ctx = internal.CtxWithStale(ctx)
 	employee, err := s.EmployeeRead(ctx, empNo)
 	if err != nil {
 		_ = handleResponse(writer, err, nil)
@@ -202,6 +208,7 @@ func (s *service) endpointEmployeeRead(writer http.ResponseWriter, request *http
 	}
 	_ = handleResponse(writer, nil, &data.Response{
 		Employee: employee,
+		Stale:    internal.StaleFromCtx(ctx),
 	})
 	s.Trace(ctx, "executed employee_read: %d", employee.EmpNo)
 }
@@ -224,6 +231,7 @@ func (s *service) endpointEmployeesSearch(writer http.ResponseWriter, request *h
 		return
 	}
 	search.FromParams(request.Form)
+	ctx = internal.CtxWithStale(ctx)
 	employees, err := s.EmployeesSearch(ctx, search)
 	if err != nil {
 		_ = handleResponse(writer, err)
@@ -231,6 +239,7 @@ func (s *service) endpointEmployeesSearch(writer http.ResponseWriter, request *h
 	}
 	_ = handleResponse(writer, nil, &data.Response{
 		Employees: employees,
+		Stale:     internal.StaleFromCtx(ctx),
 	})
 	s.Trace(ctx, "executed employees_search")
 }
@@ -343,6 +352,58 @@ func (s *service) endpointCacheClear(writer http.ResponseWriter, request *http.R
 	_ = handleResponse(writer, nil, nil)
 }
 
+func (s *service) endpointCacheKeysRead(writer http.ResponseWriter, request *http.Request) {
+	ctx := internal.CtxWithCorrelationId(request.Context(),
+		getCorrelationId(request))
+	if s.inspector == nil {
+		_ = handleResponse(writer, cache.ErrInspectionUnsupported, nil)
+		return
+	}
+	entryType := request.URL.Query().Get(data.ParameterEntryType)
+	keys, err := s.inspector.KeysRead(ctx, entryType)
+	if err != nil {
+		_ = handleResponse(writer, err, nil)
+		return
+	}
+	_ = handleResponse(writer, nil, &data.CacheKeys{Keys: keys})
+	s.Trace(ctx, "executed cache_keys_read: %s", entryType)
+}
+
+func (s *service) endpointCacheEntryRead(writer http.ResponseWriter, request *http.Request) {
+	ctx := internal.CtxWithCorrelationId(request.Context(),
+		getCorrelationId(request))
+	if s.inspector == nil {
+		_ = handleResponse(writer, cache.ErrInspectionUnsupported, nil)
+		return
+	}
+	pathVariables := mux.Vars(request)
+	entryType, key := pathVariables[data.PathEntryType], pathVariables[data.PathKey]
+	entry, err := s.inspector.EntryRead(ctx, entryType, key)
+	if err != nil {
+		_ = handleResponse(writer, err, nil)
+		return
+	}
+	_ = handleResponse(writer, nil, entry)
+	s.Trace(ctx, "executed cache_entry_read: %s:%s", entryType, key)
+}
+
+func (s *service) endpointCacheEntryDelete(writer http.ResponseWriter, request *http.Request) {
+	ctx := internal.CtxWithCorrelationId(request.Context(),
+		getCorrelationId(request))
+	if s.inspector == nil {
+		_ = handleResponse(writer, cache.ErrInspectionUnsupported, nil)
+		return
+	}
+	pathVariables := mux.Vars(request)
+	entryType, key := pathVariables[data.PathEntryType], pathVariables[data.PathKey]
+	if err := s.inspector.EntryDelete(ctx, entryType, key); err != nil {
+		_ = handleResponse(writer, err, nil)
+		return
+	}
+	_ = handleResponse(writer, nil, nil)
+	s.Trace(ctx, "executed cache_entry_delete: %s:%s", entryType, key)
+}
+
 func (s *service) endpointCacheCountersRead(writer http.ResponseWriter, _ *http.Request) {
 	_ = handleResponse(writer, nil, s.Counter.ReadAll())
 }
@@ -367,9 +428,20 @@ func (s *service) endpointTimersClear(writer http.ResponseWriter, request *http.
 	s.Trace(ctx, "executed timers_clear")
 }
 
+func (s *service) endpointReady(writer http.ResponseWriter, _ *http.Request) {
+	for _, readier := range s.readiers {
+		if !readier.Ready() {
+			writer.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+	}
+	_ = handleResponse(writer, nil, nil)
+}
+
 func (s *service) buildRoutes() {
 	s.Router.HandleFunc("/", s.endpointDefault())
 	s.Router.HandleFunc(data.RouteSleep, s.endpointSleep)
+	s.Router.HandleFunc(data.RouteReady, s.endpointReady)
 	s.Router.HandleFunc(data.RouteEmployeesSearch, s.endpointEmployeesSearch)
 	s.Router.HandleFunc(data.RouteEmployees, func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
@@ -409,6 +481,24 @@ func (s *service) buildRoutes() {
 			s.endpointCacheClear(w, r)
 		}
 	})
+	s.Router.HandleFunc(data.RouteCacheKeys, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case http.MethodGet:
+			s.endpointCacheKeysRead(w, r)
+		}
+	})
+	s.Router.HandleFunc(data.RouteCacheEntry, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		case http.MethodGet:
+			s.endpointCacheEntryRead(w, r)
+		case http.MethodDelete:
+			s.endpointCacheEntryDelete(w, r)
+		}
+	})
 	s.Router.HandleFunc(data.RouteTimers, func(w http.ResponseWriter, r *http.Request) {
 		switch r.Method {
 		default:
@@ -3,6 +3,7 @@ package sql
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
 	"time"
@@ -23,6 +24,28 @@ func employeeCriteria(search data.EmployeeSearch) (string, []interface{}) {
 		}
 		criteria = append(criteria, fmt.Sprintf("emp_no IN(%s)", strings.Join(parameters, ",")))
 	}
+	if firstNames := search.FirstNames; len(firstNames) > 0 {
+		var parameters []string
+
+		for _, firstName := range firstNames {
+			args = append(args, firstName)
+			parameters = append(parameters, "?")
+		}
+		criteria = append(criteria, fmt.Sprintf("first_name IN(%s)", strings.Join(parameters, ",")))
+	}
+	if lastNames := search.LastNames; len(lastNames) > 0 {
+		var parameters []string
+
+		for _, lastName := range lastNames {
+			args = append(args, lastName)
+			parameters = append(parameters, "?")
+		}
+		criteria = append(criteria, fmt.Sprintf("last_name IN(%s)", strings.Join(parameters, ",")))
+	}
+	if gender := search.Gender; gender != "" {
+		args = append(args, gender)
+		criteria = append(criteria, "gender = ?")
+	}
 	if len(criteria) <= 0 {
 		return "", nil
 	}
@@ -48,6 +71,29 @@ func employeeScan(scanFx func(...interface{}) error) (*data.Employee, error) {
 	return employee, nil
 }
 
+// employeeRead reads the employee, if forUpdate is true, the employee is
+// locked until the transaction it's read with completes
+func employeeRead(ctx context.Context, db interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}, empNo int64, forUpdate bool) (*data.Employee, error) {
+	query := fmt.Sprintf(`SELECT emp_no, birth_date, first_name, last_name,
+		gender, hire_date FROM %s WHERE emp_no = ?`, tableEmployees)
+	if forUpdate {
+		query += " FOR UPDATE"
+	}
+	row := db.QueryRowContext(ctx, query+";", empNo)
+	employee, err := employeeScan(row.Scan)
+	if err != nil {
+		switch {
+		default:
+			return nil, err
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrEmployeeNotFound
+		}
+	}
+	return employee, nil
+}
+
 func findEmpNo(ctx context.Context, db *sql.DB) (int64, error) {
 	var empNo int64
 
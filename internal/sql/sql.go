@@ -3,7 +3,6 @@ package sql
 import (
 	"context"
 	"database/sql"
-	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -25,7 +24,8 @@ type Sql interface {
 	EmployeeCreate(ctx context.Context, employeePartial data.EmployeePartial) (*data.Employee, error)
 	EmployeeRead(ctx context.Context, empNo int64) (*data.Employee, error)
 	EmployeesSearch(ctx context.Context, search data.EmployeeSearch) ([]*data.Employee, error)
-	EmployeeUpdate(ctx context.Context, empNo int64, employeePartial data.EmployeePartial) (*data.Employee, error)
+	EmployeesRecentlyHired(ctx context.Context, limit int) ([]*data.Employee, error)
+	EmployeeUpdate(ctx context.Context, empNo int64, employeePartial data.EmployeePartial) (employee, employeePrevious *data.Employee, err error)
 	EmployeeDelete(ctx context.Context, empNo int64) error
 
 	Sleep(ctx context.Context, sleep data.Sleep) (*data.Sleep, error)
@@ -160,20 +160,7 @@ func (s *mySql) EmployeeCreate(ctx context.Context, employeePartial data.Employe
 }
 
 func (s *mySql) EmployeeRead(ctx context.Context, empNo int64) (*data.Employee, error) {
-	query := fmt.Sprintf(`SELECT emp_no, birth_date, first_name, last_name,
-		gender, hire_date FROM %s WHERE emp_no = ?;`,
-		tableEmployees)
-	row := s.QueryRowContext(ctx, query, empNo)
-	employee, err := employeeScan(row.Scan)
-	if err != nil {
-		switch {
-		default:
-			return nil, err
-		case errors.Is(err, sql.ErrNoRows):
-			return nil, ErrEmployeeNotFound
-		}
-	}
-	return employee, nil
+	return employeeRead(ctx, s.DB, empNo, false)
 }
 
 func (s *mySql) EmployeesSearch(ctx context.Context, search data.EmployeeSearch) ([]*data.Employee, error) {
@@ -200,7 +187,37 @@ func (s *mySql) EmployeesSearch(ctx context.Context, search data.EmployeeSearch)
 	return employees, nil
 }
 
-func (s *mySql) EmployeeUpdate(ctx context.Context, empNo int64, employeePartial data.EmployeePartial) (*data.Employee, error) {
+// EmployeesRecentlyHired returns up to limit employees, ordered by
+// most recently hired
+func (s *mySql) EmployeesRecentlyHired(ctx context.Context, limit int) ([]*data.Employee, error) {
+	var employees []*data.Employee
+
+	query := fmt.Sprintf(`SELECT emp_no, birth_date, first_name, last_name,
+		gender, hire_date FROM %s ORDER BY hire_date DESC, emp_no DESC LIMIT ?;`,
+		tableEmployees)
+	rows, err := s.QueryContext(ctx, query, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		employee, err := employeeScan(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		employees = append(employees, employee)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return employees, nil
+}
+
+// EmployeeUpdate will update the employee and return the employee as it
+// was before the update (previous) alongside the updated employee; the
+// employee is locked while it's updated so previous is always the
+// employee that was updated
+func (s *mySql) EmployeeUpdate(ctx context.Context, empNo int64, employeePartial data.EmployeePartial) (*data.Employee, *data.Employee, error) {
 	var args []any
 	var updates []string
 
@@ -227,10 +244,28 @@ func (s *mySql) EmployeeUpdate(ctx context.Context, empNo int64, employeePartial
 	query := fmt.Sprintf("UPDATE %s SET %s WHERE emp_no = ?", tableEmployees,
 		strings.Join(updates, ","))
 	args = append(args, empNo)
-	if _, err := s.ExecContext(ctx, query, args...); err != nil {
-		return nil, err
+	tx, err := s.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
 	}
-	return s.EmployeeRead(ctx, empNo)
+	defer func() {
+		_ = tx.Rollback()
+	}()
+	employeePrevious, err := employeeRead(ctx, tx, empNo, true)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, nil, err
+	}
+	employee, err := employeeRead(ctx, tx, empNo, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+	return employee, employeePrevious, nil
 }
 
 func (s *mySql) EmployeeDelete(ctx context.Context, empNo int64) error {
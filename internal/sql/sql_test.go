@@ -95,11 +95,12 @@ func (s *sqlTest) TestSql(t *testing.T) {
 	// update employee
 	updatedFirstName := internal.GenerateId()[:14]
 	updatedLastName := internal.GenerateId()[:16]
-	employeeUpdated, err := s.EmployeeUpdate(ctx, empNo, data.EmployeePartial{
+	employeeUpdated, employeePrevious, err := s.EmployeeUpdate(ctx, empNo, data.EmployeePartial{
 		FirstName: &updatedFirstName,
 		LastName:  &updatedLastName,
 	})
 	assert.Nil(t, err)
+	assert.Equal(t, employeeCreated, employeePrevious)
 	assert.NotNil(t, employeeUpdated)
 	assert.NotEqual(t, firstName, employeeUpdated.FirstName)
 	assert.NotEqual(t, lastName, employeeUpdated.LastName)
@@ -0,0 +1,28 @@
+package swagger
+
+// swagger:route DELETE /cache/{entry_type}/{key} Cache DeleteCacheEntry
+// Evicts a single cached entry.
+//
+//     Consumes:
+//     - application/json
+//
+//     Produces:
+//     - application/json
+//
+// responses:
+//   204: CacheEntryDeleteResponseNoContent
+
+// swagger:response CacheEntryDeleteResponseNoContent
+type CacheEntryDeleteResponseNoContent struct{}
+
+// swagger:parameters DeleteCacheEntry
+type CacheEntryDeleteParams struct {
+	// in:header
+	CorrelationId string `json:"Correlation-Id"`
+
+	// in:path
+	EntryType string `json:"entry_type"`
+
+	// in:path
+	Key string `json:"key"`
+}
@@ -0,0 +1,33 @@
+package swagger
+
+import "github.com/antonio-alexander/go-blog-cache/internal/data"
+
+// swagger:route GET /cache/{entry_type}/{key} Cache ReadCacheEntry
+// Reads a single cached entry as stored.
+//
+//     Consumes:
+//     - application/json
+//
+//     Produces:
+//     - application/json
+//
+// responses:
+//   200: CacheEntryGetResponseOk
+
+// swagger:response CacheEntryGetResponseOk
+type CacheEntryGetResponseOk struct {
+	// in:body
+	CacheEntry data.CacheEntry `json:"cache_entry"`
+}
+
+// swagger:parameters ReadCacheEntry
+type CacheEntryGetParams struct {
+	// in:header
+	CorrelationId string `json:"Correlation-Id"`
+
+	// in:path
+	EntryType string `json:"entry_type"`
+
+	// in:path
+	Key string `json:"key"`
+}
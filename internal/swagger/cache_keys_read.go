@@ -0,0 +1,30 @@
+package swagger
+
+import "github.com/antonio-alexander/go-blog-cache/internal/data"
+
+// swagger:route GET /cache/keys Cache ReadCacheKeys
+// Reads the keys of cached entries with their age and remaining ttl.
+//
+//     Consumes:
+//     - application/json
+//
+//     Produces:
+//     - application/json
+//
+// responses:
+//   200: CacheKeysGetResponseOk
+
+// swagger:response CacheKeysGetResponseOk
+type CacheKeysGetResponseOk struct {
+	// in:body
+	CacheKeys data.CacheKeys `json:"cache_keys"`
+}
+
+// swagger:parameters ReadCacheKeys
+type CacheKeysGetParams struct {
+	// in:header
+	CorrelationId string `json:"Correlation-Id"`
+
+	// in:query
+	EntryType string `json:"entry_type"`
+}
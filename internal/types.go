@@ -18,3 +18,9 @@ type Closer interface {
 type Clearer interface {
 	Clear(ctx context.Context) error
 }
+
+// Readier can be implemented by anything that the readiness
+// of the service depends on
+type Readier interface {
+	Ready() bool
+}
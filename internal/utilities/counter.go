@@ -7,8 +7,10 @@ import (
 )
 
 type counter struct {
-	hit  int
-	miss int
+	hit       int
+	miss      int
+	coalesced int
+	stale     int
 }
 
 type cacheCounter struct {
@@ -21,6 +23,8 @@ type Counter interface {
 	ReadAll() *data.CacheCounters
 	IncrementHit(key string) (hitCount int)
 	IncrementMiss(key string) (missCount int)
+	IncrementCoalesced(key string) (coalescedCount int)
+	IncrementStale(key string) (staleCount int)
 	Reset()
 }
 
@@ -54,13 +58,23 @@ func (c *cacheCounter) ReadAll() *data.CacheCounters {
 
 	counterHit := make(map[string]int)
 	counterMiss := make(map[string]int)
+	counterCoalesced := make(map[string]int)
+	counterStale := make(map[string]int)
 	for key, value := range c.counters {
 		counterHit[key] = value.hit
 		counterMiss[key] = value.miss
+		if value.coalesced > 0 {
+			counterCoalesced[key] = value.coalesced
+		}
+		if value.stale > 0 {
+			counterStale[key] = value.stale
+		}
 	}
 	return &data.CacheCounters{
-		CounterHits:   counterHit,
-		CounterMisses: counterMiss,
+		CounterHits:      counterHit,
+		CounterMisses:    counterMiss,
+		CounterCoalesced: counterCoalesced,
+		CounterStale:     counterStale,
 	}
 }
 
@@ -97,3 +111,29 @@ func (c *cacheCounter) IncrementMiss(key string) int {
 	cntr.miss++
 	return cntr.miss
 }
+
+func (c *cacheCounter) IncrementCoalesced(key string) int {
+	c.Lock()
+	defer c.Unlock()
+
+	cntr, found := c.counters[key]
+	if !found {
+		cntr = &counter{}
+		c.counters[key] = cntr
+	}
+	cntr.coalesced++
+	return cntr.coalesced
+}
+
+func (c *cacheCounter) IncrementStale(key string) int {
+	c.Lock()
+	defer c.Unlock()
+
+	cntr, found := c.counters[key]
+	if !found {
+		cntr = &counter{}
+		c.counters[key] = cntr
+	}
+	cntr.stale++
+	return cntr.stale
+}